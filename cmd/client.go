@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -12,17 +13,26 @@ import (
 )
 
 type clientOpts struct {
-	debug   bool // controls whether debug info is added to response json
-	verbose bool // controls whether verbose Solr requests/responses are logged
+	debug     bool   // controls whether debug info is added to response json
+	verbose   bool   // controls whether verbose Solr requests/responses are logged
+	countOnly bool   // controls whether only the number of parts is returned
+	partType  string // controls which type of parts are returned, if set
+	pretty    bool   // controls whether response json is indented (admin only)
+	meta      bool   // controls whether response metadata (e.g. the answering core) is included
+	timing    bool   // controls whether a backend timing breakdown is included (admin only)
 }
 
 type clientContext struct {
-	reqID  string          // internally generated
-	start  time.Time       // internally set
-	opts   clientOpts      // options set by client
-	claims *v4jwt.V4Claims // information about this user
-	nolog  bool            // internally set
-	ginCtx *gin.Context    // gin context
+	reqID     string          // internally generated
+	start     time.Time       // internally set
+	opts      clientOpts      // options set by client
+	languages []string        // preferred languages from Accept-Language, most preferred first
+	timeout   time.Duration   // time the client is willing to wait, from deadline headers; 0 if none
+	claims    *v4jwt.V4Claims // information about this user
+	nolog     bool            // internally set
+	sampled   bool            // internally set; unsampled requests hold log lines unless an error occurs
+	held      []string        // log lines held back for an unsampled request
+	ginCtx    *gin.Context    // gin context
 }
 
 func boolOptionWithFallback(opt string, fallback bool) bool {
@@ -39,16 +49,34 @@ func boolOptionWithFallback(opt string, fallback bool) bool {
 func (c *clientContext) init(p *serviceContext, ctx *gin.Context) {
 	c.ginCtx = ctx
 
+	// make this client available to middleware (e.g. panic recovery) that runs around handlers
+	ctx.Set("client", c)
+
 	c.start = time.Now()
-	c.reqID = fmt.Sprintf("%08x", p.randomSource.Uint32())
+	// the request id doubles as the log sampling decision
+	sample := p.randomSource.Uint32()
+	c.reqID = fmt.Sprintf("%08x", sample)
+	c.sampled = float64(sample) < p.logSampleRate*float64(math.MaxUint32)
 
 	// get claims, if any
 	if val, ok := ctx.Get("claims"); ok == true {
 		c.claims = val.(*v4jwt.V4Claims)
 	}
 
+	c.languages = acceptedLanguages(ctx.GetHeader("Accept-Language"))
+	c.timeout = clientTimeout(ctx.GetHeader("X-Request-Deadline"), ctx.GetHeader("grpc-timeout"), c.start)
+
 	c.opts.debug = boolOptionWithFallback(ctx.Query("debug"), false)
 	c.opts.verbose = boolOptionWithFallback(ctx.Query("verbose"), false)
+	c.opts.countOnly = boolOptionWithFallback(ctx.Query("countonly"), false)
+	c.opts.partType = ctx.Query("part_type")
+	c.opts.pretty = boolOptionWithFallback(ctx.Query("pretty"), false) && c.isAdmin()
+	c.opts.meta = boolOptionWithFallback(ctx.Query("meta"), false)
+	c.opts.timing = boolOptionWithFallback(ctx.Query("timing"), false) && c.isAdmin()
+}
+
+func (c *clientContext) isAdmin() bool {
+	return c.claims != nil && c.claims.Role == v4jwt.Admin
 }
 
 func (c *clientContext) logRequest() {
@@ -62,7 +90,7 @@ func (c *clientContext) logRequest() {
 		claimsStr = fmt.Sprintf("  [%s; %s; %s; %v]", c.claims.UserID, c.claims.Role, c.claims.AuthMethod, c.claims.IsUVA)
 	}
 
-	c.log("[REQUEST] %s %s%s  %s", c.ginCtx.Request.Method, c.ginCtx.Request.URL.Path, query, claimsStr)
+	c.log("[REQUEST] %s %s%s  [%s]%s", c.ginCtx.Request.Method, c.ginCtx.Request.URL.Path, query, c.ginCtx.ClientIP(), claimsStr)
 }
 
 func (c *clientContext) logResponse(resp searchResponse) {
@@ -70,6 +98,7 @@ func (c *clientContext) logResponse(resp searchResponse) {
 
 	if resp.err != nil {
 		msg = msg + fmt.Sprintf(", error: %s", resp.err.Error())
+		c.release()
 	}
 
 	c.log(msg)
@@ -82,7 +111,25 @@ func (c *clientContext) printf(prefix, format string, args ...interface{}) {
 		str = strings.Join([]string{prefix, str}, " ")
 	}
 
-	log.Printf("[%s] %s", c.reqID, str)
+	line := fmt.Sprintf("[%s] %s", c.reqID, str)
+
+	if c.sampled == false {
+		c.held = append(c.held, line)
+		return
+	}
+
+	log.Print(line)
+}
+
+func (c *clientContext) release() {
+	// emit any held log lines, and stop holding further ones
+	c.sampled = true
+
+	for _, line := range c.held {
+		log.Print(line)
+	}
+
+	c.held = nil
 }
 
 func (c *clientContext) log(format string, args ...interface{}) {
@@ -94,5 +141,6 @@ func (c *clientContext) log(format string, args ...interface{}) {
 }
 
 func (c *clientContext) err(format string, args ...interface{}) {
+	c.release()
 	c.printf("ERROR:", format, args...)
 }
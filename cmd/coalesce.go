@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// queryCoalescer lets concurrent identical solr queries share a single backend call.
+// only successful responses are shared: a failure may be specific to the caller that
+// made the call (e.g. its own deadline), so waiters make their own call instead.
+
+type coalescedQuery struct {
+	done chan struct{}
+	res  solrResponse
+	err  error
+}
+
+type queryCoalescer struct {
+	mu      sync.Mutex
+	pending map[string]*coalescedQuery
+}
+
+func newQueryCoalescer() *queryCoalescer {
+	return &queryCoalescer{pending: make(map[string]*coalescedQuery)}
+}
+
+// do returns the result of fn, or of an identical in-flight fn, and whether it was shared
+func (q *queryCoalescer) do(ctx context.Context, key string, fn func() (solrResponse, error)) (solrResponse, error, bool) {
+	q.mu.Lock()
+
+	if call, ok := q.pending[key]; ok == true {
+		q.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return solrResponse{}, ctx.Err(), false
+		}
+
+		if call.err != nil {
+			res, err := fn()
+			return res, err, false
+		}
+
+		return call.res, nil, true
+	}
+
+	call := &coalescedQuery{done: make(chan struct{})}
+	q.pending[key] = call
+	q.mu.Unlock()
+
+	call.res, call.err = fn()
+
+	q.mu.Lock()
+	delete(q.pending, key)
+	q.mu.Unlock()
+
+	close(call.done)
+
+	return call.res, call.err, false
+}
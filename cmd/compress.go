@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bufferedWriter holds the response body so that its size is known before
+// deciding whether to compress it
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// proxied pdf downloads are streamed as-is: pdfs gain little from compression, buffering
+// them is costly, and compression would invalidate byte ranges
+var uncompressedPaths = regexp.MustCompile(`/pdf/download$`)
+
+// sizedGzip compresses responses whose size falls within [minBytes, maxBytes];
+// smaller responses are not worth compressing, and larger ones cost more CPU
+// time than they save in transfer time.  a maxBytes of 0 means no maximum.
+func sizedGzip(minBytes, maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") == false || uncompressedPaths.MatchString(c.Request.URL.Path) == true {
+			return
+		}
+
+		orig := c.Writer
+		buf := &bufferedWriter{ResponseWriter: orig}
+		c.Writer = buf
+
+		c.Next()
+
+		c.Writer = orig
+
+		size := buf.body.Len()
+
+		compress := size > 0 && size >= minBytes && (maxBytes == 0 || size <= maxBytes) &&
+			orig.Header().Get("Content-Encoding") == ""
+
+		if compress == false {
+			orig.Write(buf.body.Bytes())
+			return
+		}
+
+		orig.Header().Set("Content-Encoding", "gzip")
+		orig.Header().Set("Vary", "Accept-Encoding")
+		orig.Header().Del("Content-Length")
+
+		gz := gzip.NewWriter(orig)
+		gz.Write(buf.body.Bytes())
+		gz.Close()
+	}
+}
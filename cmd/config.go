@@ -12,16 +12,42 @@ import (
 const envPrefix = "VIRGO4_DIGITAL_CONTENT_WS"
 
 type serviceConfigSolrParams struct {
-	Qt      string   `json:"qt,omitempty"`
-	DefType string   `json:"deftype,omitempty"`
-	Fq      []string `json:"fq,omitempty"`
-	Fl      []string `json:"fl,omitempty"`
+	Qt               string                      `json:"qt,omitempty"`
+	DefType          string                      `json:"deftype,omitempty"`
+	Fq               []string                    `json:"fq,omitempty"`
+	Fl               []string                    `json:"fl,omitempty"`
+	Mm               string                      `json:"mm,omitempty"`
+	Qf               string                      `json:"qf,omitempty"`
+	ShardsPreference string                      `json:"shards_preference,omitempty"` // e.g. "replica.type:PULL"
+	JSONNL           string                      `json:"json_nl,omitempty"`           // named list output format: "flat" (solr default), "map", "arrarr", "arrmap", or "arrntv"
+	Highlight        serviceConfigSolrHighlight  `json:"highlight,omitempty"`
+	Group            serviceConfigSolrGroup      `json:"group,omitempty"`
+	Spellcheck       serviceConfigSolrSpellcheck `json:"spellcheck,omitempty"`
+}
+
+type serviceConfigSolrSpellcheck struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	Dictionary string `json:"dictionary,omitempty"` // spellcheck dictionary; solr's default if unset
+	Count      string `json:"count,omitempty"`      // suggestions per term; solr's default if unset
+}
+
+type serviceConfigSolrGroup struct {
+	Field    string `json:"field,omitempty"`    // field to group results on; grouping is disabled if unset
+	Collapse bool   `json:"collapse,omitempty"` // use the collapse query parser instead of result grouping
+}
+
+type serviceConfigSolrHighlight struct {
+	Enabled  bool     `json:"enabled,omitempty"`
+	Fl       []string `json:"fl,omitempty"`       // fields to highlight; solr's default if unset
+	Snippets string   `json:"snippets,omitempty"` // snippets per field
+	Fragsize string   `json:"fragsize,omitempty"` // snippet size, in characters
 }
 
 type serviceConfigSolrClient struct {
 	Endpoint    string `json:"endpoint,omitempty"`
 	ConnTimeout string `json:"conn_timeout,omitempty"`
 	ReadTimeout string `json:"read_timeout,omitempty"`
+	IdleTimeout string `json:"idle_timeout,omitempty"` // seconds before idle connections are closed; keep below any load balancer idle timeout (default: 90)
 }
 
 type serviceConfigSolrClients struct {
@@ -29,11 +55,26 @@ type serviceConfigSolrClients struct {
 	HealthCheck serviceConfigSolrClient `json:"healthcheck,omitempty"`
 }
 
+type serviceConfigSolrUnavailable struct {
+	RetryAfter string `json:"retry_after,omitempty"` // Retry-After seconds (default: 30)
+	Message    string `json:"message,omitempty"`     // error message (default: "service temporarily unavailable")
+}
+
 type serviceConfigSolr struct {
-	Host    string                   `json:"host,omitempty"`
-	Core    string                   `json:"core,omitempty"`
-	Clients serviceConfigSolrClients `json:"clients,omitempty"`
-	Params  serviceConfigSolrParams  `json:"params,omitempty"`
+	Host            string                       `json:"host,omitempty"`
+	Core            string                       `json:"core,omitempty"`
+	Cores           map[string]string            `json:"cores,omitempty"`           // additional named cores selectable per request (optional)
+	RealTimeGet     string                       `json:"realtime_get,omitempty"`    // real-time get endpoint, tried when a query finds nothing (optional)
+	IDPattern       string                       `json:"id_pattern,omitempty"`      // regex that requested ids must match in full (optional)
+	IDMaxLength     string                       `json:"id_max_length,omitempty"`   // maximum requested id length (optional)
+	LookupFields    []string                     `json:"lookup_fields,omitempty"`   // solr fields tried in order until one matches the id (default: id)
+	FoldFieldCase   bool                         `json:"fold_field_case,omitempty"` // resolve configured solr field names case-insensitively (default: exact)
+	Clients         serviceConfigSolrClients     `json:"clients,omitempty"`
+	Params          serviceConfigSolrParams      `json:"params,omitempty"`
+	QueryTimeoutMS  string                       `json:"query_timeout_ms,omitempty"` // per-query time limit, within the client read timeout (optional)
+	CoalesceQueries bool                         `json:"coalesce_queries,omitempty"` // let concurrent identical queries share one solr request
+	Headers         map[string]string            `json:"headers,omitempty"`          // extra headers sent on every solr request (values are never logged)
+	Unavailable     serviceConfigSolrUnavailable `json:"unavailable,omitempty"`      // response when solr cannot be reached
 }
 
 type serviceConfigPdfEndpoints struct {
@@ -44,43 +85,192 @@ type serviceConfigPdfEndpoints struct {
 }
 
 type serviceConfigPdf struct {
-	ConnTimeout string                    `json:"conn_timeout,omitempty"`
-	ReadTimeout string                    `json:"read_timeout,omitempty"`
-	Endpoints   serviceConfigPdfEndpoints `json:"endpoints,omitempty"`
+	ConnTimeout     string                    `json:"conn_timeout,omitempty"`
+	ReadTimeout     string                    `json:"read_timeout,omitempty"`
+	Endpoints       serviceConfigPdfEndpoints `json:"endpoints,omitempty"`
+	ReadyStatuses   []string                  `json:"ready_statuses,omitempty"`    // pdf statuses meaning a pdf can be downloaded (default: "READY")
+	DefaultStatus   string                    `json:"default_status,omitempty"`    // status reported when no status endpoint is configured (default: "unknown")
+	Retries         string                    `json:"retries,omitempty"`           // retries for transient status request failures (default: 0)
+	RetryBackoffMS  string                    `json:"retry_backoff_ms,omitempty"`  // initial retry delay, doubled on each retry
+	HealthCheckURL  string                    `json:"healthcheck_url,omitempty"`   // pdf service url checked by the health check (optional)
+	StatusMap       map[string]string         `json:"status_map,omitempty"`        // raw pdf status -> "ready", "generating", "failed", "missing", or "unknown"
+	MaxStatusChecks string                    `json:"max_status_checks,omitempty"` // live status checks per request; later parts report "unknown" (default: unlimited)
+	StatusTimeoutMS string                    `json:"status_timeout_ms,omitempty"` // per-status-check time limit, within the client read timeout (optional)
+	Headers         map[string]string         `json:"headers,omitempty"`           // extra headers sent on every pdf service request (values are never logged)
+	DownloadProxy   bool                      `json:"download_proxy,omitempty"`    // stream pdf downloads through this service, for clients that cannot reach the pdf service (not bound by server write_timeout)
+}
+
+type serviceConfigIiif struct {
+	ConnTimeout    string `json:"conn_timeout,omitempty"`
+	ReadTimeout    string `json:"read_timeout,omitempty"`
+	CheckReachable bool   `json:"check_reachable,omitempty"`  // verify manifest urls with a HEAD request before emitting them
+	CheckTimeoutMS string `json:"check_timeout_ms,omitempty"` // per-check time limit, within the client read timeout (optional)
 }
 
 type poolConfigFieldTypeIIIFManifestURL struct {
 	URLPrefix string `json:"url_prefix,omitempty"`
 }
 
+type poolConfigFieldTypeThumbnails struct {
+	URLPrefix string            `json:"url_prefix,omitempty"` // iiif image api base; urls are <prefix>/<pid>/full/<size>/0/default.jpg
+	Sizes     map[string]string `json:"sizes,omitempty"`      // size name -> iiif image api size, e.g. "small": "!200,200"
+}
+
+type poolConfigFieldTypeSpriteURL struct {
+	URLPrefix string `json:"url_prefix,omitempty"` // sprite sheet service base; urls are <prefix>/<id>
+}
+
+type poolConfigFieldTypeIIIFCollectionURL struct {
+	URLPrefix string `json:"url_prefix,omitempty"` // iiif collection manifest service base; urls are <prefix>/<id>
+}
+
+type poolConfigFieldTypeOAIIdentifier struct {
+	RepositoryID string `json:"repository_id,omitempty"` // repository portion of oai:<repository>:<id>
+}
+
+type poolConfigFieldTypePdf struct {
+	RightsField        string   `json:"rights_field,omitempty"`         // solr field that marks a part as rights-restricted
+	RightsValues       []string `json:"rights_values,omitempty"`        // restricting values; any non-empty value restricts if unset
+	RightsWrapperField string   `json:"rights_wrapper_field,omitempty"` // solr field holding the url exposed in place of pdf urls
+}
+
 type servceConfigFieldCustomInfo struct {
-	IIIFManifestURL *poolConfigFieldTypeIIIFManifestURL `json:"iiif_manifest_url,omitempty"`
+	IIIFManifestURL   *poolConfigFieldTypeIIIFManifestURL   `json:"iiif_manifest_url,omitempty"`
+	Pdf               *poolConfigFieldTypePdf               `json:"pdf,omitempty"`
+	OAIIdentifier     *poolConfigFieldTypeOAIIdentifier     `json:"oai_identifier,omitempty"`
+	Thumbnails        *poolConfigFieldTypeThumbnails        `json:"thumbnails,omitempty"`
+	SpriteURL         *poolConfigFieldTypeSpriteURL         `json:"sprite_url,omitempty"`
+	IIIFCollectionURL *poolConfigFieldTypeIIIFCollectionURL `json:"iiif_collection_url,omitempty"`
 }
 
 type serviceConfigField struct {
-	Name          string                       `json:"name,omitempty"`
-	Field         string                       `json:"field,omitempty"`
-	Required      bool                         `json:"required,omitempty"`
-	DefaultPrefix string                       `json:"default_prefix,omitempty"`
-	CustomInfo    *servceConfigFieldCustomInfo `json:"custom_info,omitempty"` // extra info for certain custom formats
+	Name          string                        `json:"name,omitempty"`
+	Field         string                        `json:"field,omitempty"`
+	Required      bool                          `json:"required,omitempty"`
+	DefaultPrefix string                        `json:"default_prefix,omitempty"`
+	CustomInfo    *servceConfigFieldCustomInfo  `json:"custom_info,omitempty"`    // extra info for certain custom formats
+	Languages     map[string]string             `json:"languages,omitempty"`      // language tag -> solr field; Field is the fallback
+	TrimSpace     bool                          `json:"trim_space,omitempty"`     // strip leading/trailing whitespace from values
+	CollapseSpace bool                          `json:"collapse_space,omitempty"` // collapse internal whitespace runs to a single space
+	MultiValued   bool                          `json:"multi_valued,omitempty"`   // emit all values rather than just the first (item fields only)
+	Transforms    []serviceConfigFieldTransform `json:"transforms,omitempty"`     // value transforms, applied in order
+	MaxValues     string                        `json:"max_values,omitempty"`     // cap on values emitted for a multi-valued field (default: unlimited)
+	ValidateURL   string                        `json:"validate_url,omitempty"`   // values must be absolute urls: "warn" logs bad values, "drop" also omits them (default: unchecked)
+	Thumbnail     bool                          `json:"thumbnail,omitempty"`      // this field holds each part's thumbnail, for default_thumbnail_url (indexed parts fields only)
+}
+
+type serviceConfigFieldTransform struct {
+	Name  string `json:"name,omitempty"`  // trim, collapse_space, upper, lower, strip_prefix, strip_suffix
+	Value string `json:"value,omitempty"` // argument, for transforms that take one (e.g. the prefix to strip)
 }
 
 type serviceConfigParts struct {
-	Indexed []serviceConfigField `json:"indexed,omitempty"` // values taken from Solr arrays by index
-	Custom  []serviceConfigField `json:"custom,omitempty"`  // values built from other info (config, indexed values, item values)
+	Indexed             []serviceConfigField `json:"indexed,omitempty"`               // values taken from Solr arrays by index
+	Custom              []serviceConfigField `json:"custom,omitempty"`                // values built from other info (config, indexed values, item values)
+	DefaultThumbnailURL string               `json:"default_thumbnail_url,omitempty"` // thumbnail used for parts lacking one in the indexed field marked "thumbnail" (optional)
+	OnMismatch          string               `json:"on_mismatch,omitempty"`           // indexed field length mismatch handling: "fail" (default), "truncate", or "pad"
+	OnDuplicatePid      string               `json:"on_duplicate_pid,omitempty"`      // repeated pid handling: "warn" (default; all parts kept) or "dedupe" (later repeats dropped)
+	NotAvailableOK      bool                 `json:"not_available_ok,omitempty"`      // respond with {"available": false} rather than an error when there are no parts
+	TypeField           string               `json:"type_field,omitempty"`            // solr field holding each part's type, for part_type filtering (optional)
+	IncludeUntyped      bool                 `json:"include_untyped,omitempty"`       // keep parts without a type when filtering by part_type
+	SortField           string               `json:"sort_field,omitempty"`            // solr field holding each part's numeric sort key; parts are returned in key order (optional)
+	MinParts            string               `json:"min_parts,omitempty"`             // fewer parts than this is an error (default: 1)
+	CollectionField     string               `json:"collection_field,omitempty"`      // solr field naming the record's collection, for per-collection minimums (optional)
+	CollectionMinParts  map[string]string    `json:"collection_min_parts,omitempty"`  // collection -> minimum parts, overriding min_parts
+}
+
+type serviceConfigEmbargo struct {
+	Field string `json:"field,omitempty"` // solr field holding the release date; records are embargoed until then (optional)
+	Mode  string `json:"mode,omitempty"`  // "error" (403; default) or "hide" (placeholder response)
 }
 
 type serviceConfigFields struct {
-	Item  []serviceConfigField `json:"item,omitempty"`  // item-level fields
-	Parts serviceConfigParts   `json:"parts,omitempty"` // part-level fields
+	Item      []serviceConfigField `json:"item,omitempty"`       // item-level fields
+	Custom    []serviceConfigField `json:"custom,omitempty"`     // item-level values built from other info (config, item values, assembled parts)
+	Parts     serviceConfigParts   `json:"parts,omitempty"`      // part-level fields
+	Embargo   serviceConfigEmbargo `json:"embargo,omitempty"`    // release date enforcement
+	EmitEmpty bool                 `json:"emit_empty,omitempty"` // include configured fields lacking a value (as null, or [] if multi-valued) rather than omitting them
+}
+
+type serviceConfigCacheControl struct {
+	Item    string `json:"item,omitempty"`     // Cache-Control for item responses
+	ItemPdf string `json:"item_pdf,omitempty"` // Cache-Control for item responses including pdf status (e.g. "no-store"); falls back to item
+	Version string `json:"version,omitempty"`  // Cache-Control for version responses
+}
+
+type serviceConfigHealthCheck struct {
+	Optional []string `json:"optional,omitempty"` // dependencies ("solr", "pdf") whose failure degrades, rather than fails, the health check
+}
+
+type serviceConfigServer struct {
+	BasePath           string                    `json:"base_path,omitempty"`             // path prefix under which all routes are mounted (optional)
+	RequestTimeoutMS   string                    `json:"request_timeout_ms,omitempty"`    // total backend time budget per request (optional)
+	ClientTimeoutMaxMS string                    `json:"client_timeout_max_ms,omitempty"` // honor client-supplied deadline headers, up to this limit (optional)
+	RetryBudget        string                    `json:"retry_budget,omitempty"`          // total backend retries allowed per request, across solr and pdf (default: unlimited)
+	IgnorePaths        []string                  `json:"ignore_paths,omitempty"`          // paths answered without processing (default: /favicon.ico)
+	IgnoreStatus       string                    `json:"ignore_status,omitempty"`         // status code returned for ignored paths (default: 204)
+	FaviconFile        string                    `json:"favicon_file,omitempty"`          // file served for /favicon.ico instead of the ignore status (optional)
+	CacheControl       serviceConfigCacheControl `json:"cache_control,omitempty"`         // per-endpoint Cache-Control response headers (optional)
+	HealthCheck        serviceConfigHealthCheck  `json:"healthcheck,omitempty"`
+	WarmupConns        string                    `json:"warmup_conns,omitempty"`        // backend connections opened at startup, per client (default: 0)
+	TrailingSlash      string                    `json:"trailing_slash,omitempty"`      // "redirect" (default), "strict" (404), or "accept" (served as if absent)
+	PublicBaseURL      string                    `json:"public_base_url,omitempty"`     // external url of this service, excluding base_path; used for self links (optional)
+	Compression        serviceConfigCompression  `json:"compression,omitempty"`         // response size window for gzip compression (optional)
+	ResponseSize       serviceConfigResponseSize `json:"response_size,omitempty"`       // item response size limit (optional)
+	ReadTimeout        string                    `json:"read_timeout,omitempty"`        // seconds allowed to read a request, including the body (default: 30)
+	ReadHeaderTimeout  string                    `json:"read_header_timeout,omitempty"` // seconds allowed to read request headers (default: 10)
+	WriteTimeout       string                    `json:"write_timeout,omitempty"`       // seconds allowed from end of request headers to end of response (default: 60); proxied pdf downloads are exempt
+	RequiredHeaders    []string                  `json:"required_headers,omitempty"`    // headers every /api and /admin request must carry, e.g. from a gateway (optional)
+	TrustedProxies     []string                  `json:"trusted_proxies,omitempty"`     // proxy addresses/cidr ranges whose X-Forwarded-For is honored (default: none)
+}
+
+type serviceConfigCompression struct {
+	MinBytes string `json:"min_bytes,omitempty"` // smaller responses are sent uncompressed (default: 0)
+	MaxBytes string `json:"max_bytes,omitempty"` // larger responses are sent uncompressed (default: no maximum)
+}
+
+type serviceConfigResponseSize struct {
+	MaxBytes string `json:"max_bytes,omitempty"` // item responses larger than this are reported (default: no maximum)
+	Mode     string `json:"mode,omitempty"`      // "warn" (default; logged and sent) or "reject" (500)
+}
+
+type serviceConfigLog struct {
+	RedactFields []string `json:"redact_fields,omitempty"` // solr fields whose query values are masked in logs
+	SampleRate   string   `json:"sample_rate,omitempty"`   // fraction (0.0 - 1.0) of successful requests fully logged (default: 1.0)
+	Pretty       bool     `json:"pretty,omitempty"`        // indent the composite config json logged at startup
+}
+
+// serviceConfigEntitlement restricts digital content to tokens carrying a given claim value
+type serviceConfigEntitlement struct {
+	Claim  string   `json:"claim,omitempty"`  // v4 jwt claim name (e.g. "isUva", "authMethod"); unrestricted if unset
+	Values []string `json:"values,omitempty"` // claim values granting access (default: "true")
 }
 
 type serviceConfig struct {
-	Port   string              `json:"port,omitempty"`
-	JWTKey string              `json:"jwt_key,omitempty"`
-	Solr   serviceConfigSolr   `json:"solr,omitempty"`
-	Pdf    serviceConfigPdf    `json:"pdf,omitempty"`
-	Fields serviceConfigFields `json:"fields,omitempty"`
+	Port        string                   `json:"port,omitempty"`
+	JWTKey      string                   `json:"jwt_key,omitempty"`
+	Entitlement serviceConfigEntitlement `json:"entitlement,omitempty"`
+	RequireTLS  bool                     `json:"require_tls,omitempty"` // reject configured backend urls that are not https, and omit pdf sections for indexed pdf urls that are not
+	Server      serviceConfigServer      `json:"server,omitempty"`
+	Log         serviceConfigLog         `json:"log,omitempty"`
+	Solr        serviceConfigSolr        `json:"solr,omitempty"`
+	Pdf         serviceConfigPdf         `json:"pdf,omitempty"`
+	Iiif        serviceConfigIiif        `json:"iiif,omitempty"`
+	Fields      serviceConfigFields      `json:"fields,omitempty"`
+	Features    map[string]bool          `json:"features,omitempty"` // feature name -> false to disable an otherwise configured feature
+}
+
+func maskedHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+
+	masked := make(map[string]string)
+	for name := range headers {
+		masked[name] = "***"
+	}
+
+	return masked
 }
 
 func getSortedJSONEnvVars() []string {
@@ -130,7 +320,20 @@ func loadConfig() *serviceConfig {
 		cfg.Solr.Host = host
 	}
 
-	bytes, err := json.Marshal(cfg)
+	// extra backend header values may hold credentials, so are masked in the logged copy
+	logged := cfg
+	logged.Solr.Headers = maskedHeaders(cfg.Solr.Headers)
+	logged.Pdf.Headers = maskedHeaders(cfg.Pdf.Headers)
+
+	var bytes []byte
+	var err error
+
+	if cfg.Log.Pretty == true {
+		bytes, err = json.MarshalIndent(logged, "", "  ")
+	} else {
+		bytes, err = json.Marshal(logged)
+	}
+
 	if err != nil {
 		log.Printf("error encoding config json: %s", err.Error())
 		os.Exit(1)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigMasksHeaders(t *testing.T) {
+	env := envPrefix + "_JSON_99_TEST_HEADERS"
+
+	os.Setenv(env, `{"solr":{"headers":{"Authorization":"Bearer s3cret"}},"pdf":{"headers":{"X-Api-Key":"k3y"}}}`)
+	t.Cleanup(func() { os.Unsetenv(env) })
+
+	logged := captureLog(t)
+
+	cfg := loadConfig()
+
+	// the service itself gets the real values...
+
+	if cfg.Solr.Headers["Authorization"] != "Bearer s3cret" || cfg.Pdf.Headers["X-Api-Key"] != "k3y" {
+		t.Errorf("headers = %v, %v; want the configured values", cfg.Solr.Headers, cfg.Pdf.Headers)
+	}
+
+	// ...while the logged config shows only the names
+
+	if strings.Contains(logged.String(), "s3cret") == true || strings.Contains(logged.String(), "k3y") == true {
+		t.Errorf("logged config contains a header value:\n%s", logged.String())
+	}
+
+	if strings.Contains(logged.String(), `"Authorization":"***"`) == false || strings.Contains(logged.String(), `"X-Api-Key":"***"`) == false {
+		t.Errorf("logged config does not show masked headers:\n%s", logged.String())
+	}
+}
+
+func TestMaskedHeaders(t *testing.T) {
+	if masked := maskedHeaders(nil); masked != nil {
+		t.Errorf("maskedHeaders(nil) = %v; want nil", masked)
+	}
+
+	headers := map[string]string{"Authorization": "Bearer s3cret"}
+
+	masked := maskedHeaders(headers)
+
+	if len(masked) != 1 || masked["Authorization"] != "***" {
+		t.Errorf("maskedHeaders() = %v; want the value masked", masked)
+	}
+
+	if headers["Authorization"] != "Bearer s3cret" {
+		t.Errorf("maskedHeaders() modified its argument: %v", headers)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -19,12 +23,181 @@ func (p *serviceContext) itemHandler(c *gin.Context) {
 	s.init(p, &cl)
 
 	s.id = c.Param("id")
+	s.core = c.Query("core")
 
 	cl.logRequest()
 	resp := s.handleItemRequest()
 	cl.logResponse(resp)
 
+	p.stats.itemServed(resp.status)
+
 	if resp.err != nil {
+		if p.respondUnavailable(c, resp) == true {
+			return
+		}
+
+		if len(resp.problems) > 0 && cl.isAdmin() == true {
+			c.JSON(resp.status, gin.H{"error": resp.err.Error(), "problems": resp.problems})
+			return
+		}
+
+		if len(resp.suggest) > 0 {
+			c.JSON(resp.status, gin.H{"error": resp.err.Error(), "suggestions": resp.suggest})
+			return
+		}
+
+		c.String(resp.status, resp.err.Error())
+		return
+	}
+
+	cacheControl := p.config.Server.CacheControl.Item
+	if s.pdfStatus == true && p.config.Server.CacheControl.ItemPdf != "" {
+		cacheControl = p.config.Server.CacheControl.ItemPdf
+	}
+
+	setCacheControl(c, cacheControl)
+
+	// serialize here, rather than via c.JSON(), so that the response size is known before sending
+
+	var body []byte
+	var err error
+
+	if cl.opts.pretty == true {
+		body, err = json.MarshalIndent(resp.data, "", "    ")
+	} else {
+		body, err = json.Marshal(resp.data)
+	}
+
+	if err != nil {
+		cl.err("failed to serialize response: %s", err.Error())
+		c.String(http.StatusInternalServerError, "failed to serialize response")
+		return
+	}
+
+	p.responseBytes.Observe(float64(len(body)))
+	cl.log("[RESPONSE] size: %d bytes", len(body))
+
+	if max := integerWithMinimum(p.config.Server.ResponseSize.MaxBytes, 0); max > 0 && len(body) > max {
+		cl.err("response size exceeds maximum (%d > %d)", len(body), max)
+
+		if p.config.Server.ResponseSize.Mode == "reject" {
+			c.String(http.StatusInternalServerError, "response too large")
+			return
+		}
+	}
+
+	c.Data(resp.status, "application/json; charset=utf-8", body)
+}
+
+func (p *serviceContext) viewHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	s := searchContext{}
+	s.init(p, &cl)
+
+	s.id = c.Param("id")
+	s.core = c.Query("core")
+
+	cl.logRequest()
+	resp := s.handleItemRequest()
+	cl.logResponse(resp)
+
+	if resp.err != nil {
+		if p.respondUnavailable(c, resp) == true {
+			return
+		}
+
+		c.String(resp.status, resp.err.Error())
+		return
+	}
+
+	page, err := renderItemView(s.id, resp.data)
+	if err != nil {
+		cl.err("failed to render item view: %s", err.Error())
+		c.String(http.StatusInternalServerError, "failed to render item view")
+		return
+	}
+
+	c.Data(resp.status, "text/html; charset=utf-8", page)
+}
+
+func (p *serviceContext) respondUnavailable(c *gin.Context, resp searchResponse) bool {
+	// a solr outage gets the same response everywhere, whatever the underlying failure
+	if _, ok := resp.err.(solrUnavailableError); ok == false {
+		return false
+	}
+
+	c.Header("Retry-After", p.config.Solr.Unavailable.RetryAfter)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": p.config.Solr.Unavailable.Message})
+
+	return true
+}
+
+func (p *serviceContext) statsHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	reset := boolOptionWithFallback(c.Query("reset"), false)
+
+	c.JSON(http.StatusOK, p.stats.snapshot(reset))
+}
+
+func (p *serviceContext) pdfDownloadHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	s := searchContext{}
+	s.init(p, &cl)
+
+	s.id = c.Param("id")
+	s.core = c.Query("core")
+	s.urlsOnly = true
+
+	// assemble the item as usual, so that embargo and rights restrictions apply to downloads too
+
+	cl.logRequest()
+	resp := s.handleItemRequest()
+	cl.logResponse(resp)
+
+	if resp.err != nil {
+		if p.respondUnavailable(c, resp) == true {
+			return
+		}
+
+		c.String(resp.status, resp.err.Error())
+		return
+	}
+
+	downloadURL, status, err := partPdfDownloadURL(resp.data, c.Param("pid"))
+	if err != nil {
+		cl.err("pdf download: %s", err.Error())
+		c.String(status, err.Error())
+		return
+	}
+
+	s.proxyPdfDownload(c, downloadURL)
+}
+
+func (p *serviceContext) rawHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	s := searchContext{}
+	s.init(p, &cl)
+
+	s.id = c.Param("id")
+	s.core = c.Query("core")
+
+	cl.logRequest()
+	resp := s.handleRawRequest()
+	cl.logResponse(resp)
+
+	if resp.err != nil {
+		if p.respondUnavailable(c, resp) == true {
+			return
+		}
+
 		c.String(resp.status, resp.err.Error())
 		return
 	}
@@ -32,13 +205,101 @@ func (p *serviceContext) itemHandler(c *gin.Context) {
 	c.JSON(resp.status, resp.data)
 }
 
+func (p *serviceContext) explainHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	s := searchContext{}
+	s.init(p, &cl)
+
+	s.id = c.Param("id")
+	s.core = c.Query("core")
+
+	cl.logRequest()
+	resp := s.handleExplainRequest()
+	cl.logResponse(resp)
+
+	if resp.err != nil {
+		if p.respondUnavailable(c, resp) == true {
+			return
+		}
+
+		c.String(resp.status, resp.err.Error())
+		return
+	}
+
+	c.JSON(resp.status, resp.data)
+}
+
+func (p *serviceContext) schemaHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	// response field names only; the underlying solr fields are not exposed
+
+	type schemaResp struct {
+		Item  []string `json:"item"`
+		Parts []string `json:"parts"`
+	}
+
+	schema := schemaResp{Item: []string{}, Parts: []string{}}
+
+	for _, field := range p.config.Fields.Item {
+		schema.Item = append(schema.Item, field.Name)
+	}
+
+	for _, field := range p.config.Fields.Custom {
+		schema.Item = append(schema.Item, field.Name)
+	}
+
+	schema.Item = append(schema.Item, "parts")
+
+	for _, field := range p.config.Fields.Parts.Indexed {
+		schema.Parts = append(schema.Parts, field.Name)
+	}
+
+	for _, field := range p.config.Fields.Parts.Custom {
+		schema.Parts = append(schema.Parts, field.Name)
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
 func (p *serviceContext) ignoreHandler(c *gin.Context) {
+	if p.config.Server.FaviconFile != "" && strings.HasSuffix(c.Request.URL.Path, "/favicon.ico") {
+		c.File(p.config.Server.FaviconFile)
+		return
+	}
+
+	c.Status(p.ignoreStatus)
+}
+
+func (p *serviceContext) methodNotAllowedHandler(c *gin.Context) {
+	var methods []string
+
+	for _, route := range p.routes {
+		if routeMatchesPath(route.Path, c.Request.URL.Path) == true && sliceContains(methods, route.Method) == false {
+			methods = append(methods, route.Method)
+		}
+	}
+
+	c.Header("Allow", strings.Join(methods, ", "))
+	c.String(http.StatusMethodNotAllowed, "method not allowed")
 }
 
 func (p *serviceContext) versionHandler(c *gin.Context) {
 	cl := clientContext{}
 	cl.init(p, c)
 
+	setCacheControl(c, p.config.Server.CacheControl.Version)
+
+	c.Header("ETag", p.versionETag)
+
+	if etagMatches(c.GetHeader("If-None-Match"), p.versionETag) == true {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
 	c.JSON(http.StatusOK, p.version)
 }
 
@@ -49,25 +310,50 @@ func (p *serviceContext) healthCheckHandler(c *gin.Context) {
 	s := searchContext{}
 	s.init(p, &cl)
 
-	ping := s.handlePingRequest()
+	pings := make(map[string]searchResponse)
+
+	pings["solr"] = s.handlePingRequest()
+
+	if p.config.Pdf.HealthCheckURL != "" {
+		pings["pdf"] = s.handlePdfPingRequest()
+	}
 
-	// build response
+	// build response; failure of a critical dependency is an error,
+	// while failure of an optional dependency only degrades service
 
 	internalServiceError := false
+	var degraded []string
 
 	type hcResp struct {
 		Healthy bool   `json:"healthy"`
 		Message string `json:"message,omitempty"`
 	}
 
-	hcSolr := hcResp{Healthy: true}
-	if ping.err != nil {
-		internalServiceError = true
-		hcSolr = hcResp{Healthy: false, Message: ping.err.Error()}
+	hcMap := make(map[string]hcResp)
+
+	for name, ping := range pings {
+		if ping.err == nil {
+			hcMap[name] = hcResp{Healthy: true}
+			continue
+		}
+
+		hcMap[name] = hcResp{Healthy: false, Message: ping.err.Error()}
+
+		if sliceContains(p.config.Server.HealthCheck.Optional, name) == true {
+			degraded = append(degraded, name)
+		} else {
+			internalServiceError = true
+		}
 	}
 
-	hcMap := make(map[string]hcResp)
-	hcMap["solr"] = hcSolr
+	sort.Strings(degraded)
+
+	hcOverall := hcResp{Healthy: internalServiceError == false}
+	if len(degraded) > 0 {
+		hcOverall.Message = fmt.Sprintf("degraded: %s", strings.Join(degraded, ", "))
+	}
+
+	hcMap["overall"] = hcOverall
 
 	hcStatus := http.StatusOK
 	if internalServiceError == true {
@@ -77,6 +363,36 @@ func (p *serviceContext) healthCheckHandler(c *gin.Context) {
 	c.JSON(hcStatus, hcMap)
 }
 
+func (p *serviceContext) recoveryHandler(c *gin.Context) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		p.panics.Inc()
+
+		msg := fmt.Sprintf("PANIC: %v (path: %s, id: %s)\n%s", r, c.Request.URL.Path, c.Param("id"), debug.Stack())
+
+		// log through the handler's client context when available, so the request id is included
+		if val, ok := c.Get("client"); ok == true {
+			val.(*clientContext).err("%s", msg)
+		} else {
+			log.Printf("ERROR: %s", msg)
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}()
+
+	c.Next()
+}
+
+func setCacheControl(c *gin.Context, value string) {
+	if value != "" {
+		c.Header("Cache-Control", value)
+	}
+}
+
 func getBearerToken(authorization string) (string, error) {
 	components := strings.Split(strings.Join(strings.Fields(authorization), " "), " ")
 
@@ -112,3 +428,70 @@ func (p *serviceContext) authenticateHandler(c *gin.Context) {
 
 	c.Set("claims", claims)
 }
+
+func (p *serviceContext) entitlementHandler(c *gin.Context) {
+	// must follow authenticateHandler, which sets the claims
+	if p.config.Entitlement.Claim == "" {
+		return
+	}
+
+	val, ok := c.Get("claims")
+	if ok == false {
+		log.Printf("Entitlement check failed: no claims")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	claims := val.(*v4jwt.V4Claims)
+
+	value, _ := claimValue(claims, p.config.Entitlement.Claim)
+
+	if sliceContains(p.config.Entitlement.Values, value) == false {
+		log.Printf("Entitlement check failed: user %s has %s = [%s]", claims.UserID, p.config.Entitlement.Claim, value)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+}
+
+func claimValue(claims *v4jwt.V4Claims, name string) (string, bool) {
+	// returns the string form of the claim with the given json name, and whether it exists
+
+	rt := reflect.TypeOf(*claims)
+
+	for i := 0; i < rt.NumField(); i++ {
+		if strings.Split(rt.Field(i).Tag.Get("json"), ",")[0] == name {
+			return fmt.Sprintf("%v", reflect.ValueOf(*claims).Field(i).Interface()), true
+		}
+	}
+
+	return "", false
+}
+
+func (p *serviceContext) requiredHeadersHandler(c *gin.Context) {
+	for _, header := range p.config.Server.RequiredHeaders {
+		if c.GetHeader(header) == "" {
+			log.Printf("Rejecting request for %s: missing required header %s", c.Request.URL.Path, header)
+			c.String(http.StatusBadRequest, fmt.Sprintf("missing required header: %s", header))
+			c.Abort()
+			return
+		}
+	}
+}
+
+func (p *serviceContext) adminHandler(c *gin.Context) {
+	// must follow authenticateHandler, which sets the claims
+	val, ok := c.Get("claims")
+	if ok == false {
+		log.Printf("Admin authorization failed: no claims")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	claims := val.(*v4jwt.V4Claims)
+
+	if claims.Role != v4jwt.Admin {
+		log.Printf("Admin authorization failed: user %s has role %s", claims.UserID, claims.Role)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// iiifManifestReachable reports whether the manifest url can be served.  only a definitive
+// answer from the iiif server hides the manifest url; timeouts and other failures are
+// treated optimistically, so a slow iiif server cannot hold up (or strip) item responses
+func (s *searchContext) iiifManifestReachable(manifestURL string) bool {
+	if s.ctx.Err() != nil {
+		s.log("[IIIF] request time budget exhausted; assuming manifest is available")
+		return true
+	}
+
+	opCtx, cancel := s.operationContext(s.svc.config.Iiif.CheckTimeoutMS)
+	defer cancel()
+
+	req, reqErr := http.NewRequestWithContext(opCtx, "HEAD", manifestURL, nil)
+	if reqErr != nil {
+		s.log("[IIIF] NewRequest() failed: %s", reqErr.Error())
+		return true
+	}
+
+	start := time.Now()
+	res, resErr := s.svc.iiif.client.Do(req)
+	elapsedMS := int64(time.Since(start) / time.Millisecond)
+
+	// external service failure logging
+
+	if resErr != nil {
+		s.log("[IIIF] client.Do() failed: %s", resErr.Error())
+		s.err("Failed response from %s %s - %s. Elapsed Time: %d (ms)", req.Method, manifestURL, resErr.Error(), elapsedMS)
+		s.log("[IIIF] assuming manifest is available")
+		return true
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		s.log("WARNING: IIIF manifest does not exist: %s", manifestURL)
+		return false
+	}
+
+	if res.StatusCode != http.StatusOK {
+		s.err("Failed response from %s %s - %d. Elapsed Time: %d (ms)", req.Method, manifestURL, res.StatusCode, elapsedMS)
+		s.log("[IIIF] assuming manifest is available")
+		return true
+	}
+
+	// external service success logging
+
+	s.log("Successful IIIF response from %s %s. Elapsed Time: %d (ms)", req.Method, manifestURL, elapsedMS)
+
+	return true
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
@@ -26,7 +30,22 @@ func main() {
 
 	router := gin.Default()
 
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	// only forwarding headers from trusted proxies may determine the client ip
+	router.Use(svc.trustedProxyHandler)
+
+	// gin redirects to the slash-less route by default
+	router.RedirectTrailingSlash = svc.config.Server.TrailingSlash == "redirect"
+
+	router.HandleMethodNotAllowed = true
+	router.NoMethod(svc.methodNotAllowedHandler)
+
+	// a size window requires buffering each response to learn its size first
+	compression := svc.config.Server.Compression
+	if compression.MinBytes != "" || compression.MaxBytes != "" {
+		router.Use(sizedGzip(integerWithMinimum(compression.MinBytes, 0), integerWithMinimum(compression.MaxBytes, 0)))
+	} else {
+		router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPathsRegexs([]string{uncompressedPaths.String()})))
+	}
 
 	corsCfg := cors.DefaultConfig()
 	corsCfg.AllowAllOrigins = true
@@ -34,7 +53,15 @@ func main() {
 	corsCfg.AddAllowHeaders("Authorization")
 	router.Use(cors.New(corsCfg))
 
+	// recovers handler panics inside the compression/cors middleware, so the error response
+	// is written normally; gin's own recovery remains as the outermost backstop
+	router.Use(svc.recoveryHandler)
+
+	// all endpoints are mounted under the (optional) base path
+	base := router.Group(svc.config.Server.BasePath)
+
 	p := ginprometheus.NewPrometheus("gin")
+	p.MetricsPath = svc.config.Server.BasePath + p.MetricsPath
 
 	// roundabout setup of /metrics endpoint to avoid double-gzip of response
 	router.Use(p.HandlerFunc())
@@ -44,17 +71,77 @@ func main() {
 		h.ServeHTTP(c.Writer, c.Request)
 	})
 
-	router.GET("/favicon.ico", svc.ignoreHandler)
+	for _, path := range nonemptyValues(svc.config.Server.IgnorePaths) {
+		base.GET(path, svc.ignoreHandler)
+	}
+
+	base.GET("/version", svc.versionHandler)
+	base.GET("/healthcheck", svc.healthCheckHandler)
+	base.GET("/openapi.json", svc.openAPIHandler)
+
+	// health checks and metrics may come straight to the pod; only api/admin traffic must carry gateway headers
 
-	router.GET("/version", svc.versionHandler)
-	router.GET("/healthcheck", svc.healthCheckHandler)
+	if api := base.Group("/api", svc.requiredHeadersHandler); api != nil {
+		api.GET("/item/:id", svc.authenticateHandler, svc.entitlementHandler, svc.itemHandler)
+		api.GET("/schema", svc.authenticateHandler, svc.schemaHandler)
 
-	if api := router.Group("/api"); api != nil {
-		api.GET("/item/:id", svc.authenticateHandler, svc.itemHandler)
+		if svc.features.downloadProxy == true {
+			api.GET("/item/:id/parts/:pid/pdf/download", svc.authenticateHandler, svc.entitlementHandler, svc.pdfDownloadHandler)
+		}
 	}
 
+	if admin := base.Group("/admin", svc.requiredHeadersHandler); admin != nil {
+		admin.GET("/raw/:id", svc.authenticateHandler, svc.adminHandler, svc.rawHandler)
+		admin.GET("/explain/:id", svc.authenticateHandler, svc.adminHandler, svc.explainHandler)
+		admin.GET("/view/:id", svc.authenticateHandler, svc.adminHandler, svc.viewHandler)
+		admin.GET("/stats", svc.authenticateHandler, svc.adminHandler, svc.statsHandler)
+	}
+
+	svc.routes = router.Routes()
+
 	portStr := fmt.Sprintf(":%s", svc.config.Port)
 	log.Printf("[MAIN] listening on %s", portStr)
 
-	log.Fatal(router.Run(portStr))
+	var handler http.Handler = router
+	if svc.config.Server.TrailingSlash == "accept" {
+		handler = stripTrailingSlash(router)
+	}
+
+	handler = withResponseController(handler)
+
+	server := &http.Server{
+		Addr:              portStr,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(integerWithMinimum(svc.config.Server.ReadTimeout, 1)) * time.Second,
+		ReadHeaderTimeout: time.Duration(integerWithMinimum(svc.config.Server.ReadHeaderTimeout, 1)) * time.Second,
+		WriteTimeout:      time.Duration(integerWithMinimum(svc.config.Server.WriteTimeout, 1)) * time.Second,
+	}
+
+	log.Fatal(server.ListenAndServe())
+}
+
+func stripTrailingSlash(next http.Handler) http.Handler {
+	// serve "/path/" exactly as "/path", without a redirect round trip
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			r.URL.RawPath = ""
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseControllerKey carries the connection's http.ResponseController in the request
+// context, for handlers that need it: gin's response writer cannot be unwrapped to reach it
+type responseControllerKey struct{}
+
+func withResponseController(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openapi documents are assembled from the configured fields, so that
+// the described item response always matches what itemHandler returns
+
+type openAPIObject map[string]interface{}
+
+func openAPIString(description string) openAPIObject {
+	return openAPIObject{"type": "string", "description": description}
+}
+
+func stringListSchema() openAPIObject {
+	return openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}}
+}
+
+func openAPIResponse(description string, schema openAPIObject) openAPIObject {
+	resp := openAPIObject{"description": description}
+
+	if schema != nil {
+		resp["content"] = openAPIObject{"application/json": openAPIObject{"schema": schema}}
+	}
+
+	return resp
+}
+
+func openAPIParameter(name, in, description string, required bool, schema openAPIObject) openAPIObject {
+	return openAPIObject{
+		"name":        name,
+		"in":          in,
+		"description": description,
+		"required":    required,
+		"schema":      schema,
+	}
+}
+
+func (p *serviceContext) openAPIPdfSchema() openAPIObject {
+	urls := openAPIObject{
+		"generate":       openAPIString("pdf generation url"),
+		"download":       openAPIString("pdf download url"),
+		"delete":         openAPIString("pdf deletion url"),
+		"rights_wrapper": openAPIString("rights wrapper url (restricted parts only)"),
+	}
+
+	if p.config.Pdf.Endpoints.Status != "" {
+		urls["status"] = openAPIString("pdf status url")
+	}
+
+	return openAPIObject{
+		"type": "object",
+		"properties": openAPIObject{
+			"status":            openAPIString("pdf status, as reported by the pdf service"),
+			"normalized_status": openAPIObject{"type": "string", "enum": []string{"ready", "generating", "failed", "missing", "unknown"}},
+			"needs_generation":  openAPIObject{"type": "boolean", "description": "true when no pdf exists yet (normalized status \"missing\"); POST to the generate url to start one"},
+			"status_error":      openAPIObject{"type": "boolean", "description": "set if the pdf service could not be reached"},
+			"status_skipped":    openAPIObject{"type": "boolean", "description": "set if the status check was skipped due to the per-request limit"},
+			"restricted":        openAPIObject{"type": "boolean", "description": "set if the pdf is rights-restricted"},
+			"urls":              openAPIObject{"type": "object", "properties": urls},
+		},
+	}
+}
+
+func (p *serviceContext) openAPIPartSchema() openAPIObject {
+	props := openAPIObject{}
+	var required []string
+
+	for _, field := range p.config.Fields.Parts.Indexed {
+		props[field.Name] = openAPIObject{"type": "string"}
+		if field.Required == true {
+			required = append(required, field.Name)
+		}
+	}
+
+	for _, field := range p.config.Fields.Parts.Custom {
+		switch field.Name {
+		case "sequence":
+			props[field.Name] = openAPIObject{"type": "integer", "description": "1-based position of this part in the response"}
+
+		case "iiif_manifest_url":
+			props[field.Name] = openAPIString("iiif manifest url (iiif-enabled parts only)")
+
+		case "digest":
+			props[field.Name] = openAPIString("stable hash of this part's fields (excluding pdf status), for change detection")
+
+		case "thumbnails":
+			sizes := openAPIObject{}
+			for name := range field.CustomInfo.Thumbnails.Sizes {
+				sizes[name] = openAPIString("iiif image url")
+			}
+			props[field.Name] = openAPIObject{"type": "object", "description": "thumbnail urls, by size name", "properties": sizes}
+
+		case "pdf":
+			props[field.Name] = p.openAPIPdfSchema()
+		}
+	}
+
+	if p.features.selfLinks == true {
+		props["self"] = openAPIString("canonical url of this part, within its item response")
+	}
+
+	schema := openAPIObject{"type": "object", "properties": props}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func (p *serviceContext) openAPIItemSchema() openAPIObject {
+	props := openAPIObject{}
+
+	for _, field := range p.config.Fields.Item {
+		props[field.Name] = openAPIObject{"type": "string"}
+		if field.MultiValued == true {
+			props[field.Name] = stringListSchema()
+		}
+	}
+
+	for _, field := range p.config.Fields.Custom {
+		switch field.Name {
+		case "has_downloadable_pdf":
+			props[field.Name] = openAPIObject{"type": "boolean", "description": "set if any part has a ready pdf"}
+
+		case "oai_identifier":
+			props[field.Name] = openAPIString("oai-pmh identifier")
+
+		case "sprite_url":
+			props[field.Name] = openAPIString("thumbnail sprite sheet url")
+
+		case "iiif_collection_url":
+			props[field.Name] = openAPIString("iiif collection manifest url referencing all parts (multi-part records only)")
+
+		case "digitization":
+			props[field.Name] = openAPIObject{
+				"type":        "object",
+				"description": "parts present versus expected",
+				"properties": openAPIObject{
+					"expected": openAPIObject{"type": "integer"},
+					"present":  openAPIObject{"type": "integer"},
+					"complete": openAPIObject{"type": "boolean"},
+				},
+			}
+		}
+	}
+
+	props["parts"] = openAPIObject{"type": "array", "items": openAPIObject{"$ref": "#/components/schemas/Part"}}
+
+	if p.features.selfLinks == true {
+		props["self"] = openAPIString("canonical url of this item response")
+	}
+
+	if p.features.highlighting == true {
+		props["highlighting"] = openAPIObject{"type": "object", "description": "solr highlighting snippets, by field", "additionalProperties": stringListSchema()}
+	}
+
+	props["meta"] = openAPIObject{
+		"type":        "object",
+		"description": "response metadata (meta requests only)",
+		"properties":  openAPIObject{"core": openAPIString("solr core that answered")},
+	}
+
+	props["timing"] = openAPIObject{
+		"type":        "object",
+		"description": "backend timing breakdown, in milliseconds (admin timing requests only)",
+		"properties": openAPIObject{
+			"total_ms":     openAPIObject{"type": "integer"},
+			"solr_ms":      openAPIObject{"type": "integer"},
+			"pdf_ms":       openAPIObject{"type": "array", "items": openAPIObject{"type": "integer"}},
+			"pdf_total_ms": openAPIObject{"type": "integer"},
+			"assembly_ms":  openAPIObject{"type": "integer"},
+		},
+	}
+
+	props["truncated"] = openAPIObject{
+		"type":                 "object",
+		"description":          "original value counts of multi-valued fields that were capped, by field",
+		"additionalProperties": openAPIObject{"type": "integer"},
+	}
+
+	props["resolved"] = openAPIObject{
+		"type":        "object",
+		"description": "canonical id, when the requested id only matched a secondary lookup field",
+		"properties": openAPIObject{
+			"id":         openAPIString("canonical item id"),
+			"matched_by": openAPIString("solr field the requested id matched"),
+		},
+	}
+
+	props["degraded"] = openAPIObject{"type": "boolean", "description": "set if some information could not be retrieved in time"}
+
+	return openAPIObject{"type": "object", "properties": props, "required": []string{"parts"}}
+}
+
+func (p *serviceContext) openAPIItemCountSchema() openAPIObject {
+	return openAPIObject{
+		"type":        "object",
+		"description": "part count only (countonly requests)",
+		"properties": openAPIObject{
+			"id":         openAPIString("item id"),
+			"part_count": openAPIObject{"type": "integer"},
+		},
+		"required": []string{"id", "part_count"},
+	}
+}
+
+func (p *serviceContext) openAPIItemPlaceholderSchema() openAPIObject {
+	return openAPIObject{
+		"type":        "object",
+		"description": "stands in for an item with no digital parts, or one under embargo",
+		"properties": openAPIObject{
+			"id":        openAPIString("item id"),
+			"available": openAPIObject{"type": "boolean", "enum": []bool{false}},
+			"embargoed": openAPIObject{"type": "boolean", "description": "set if the item is under embargo"},
+		},
+		"required": []string{"id", "available"},
+	}
+}
+
+func (p *serviceContext) openAPIDocument() openAPIObject {
+	bearer := []openAPIObject{{"bearerAuth": []string{}}}
+
+	idParam := openAPIParameter("id", "path", "item identifier", true, openAPIObject{"type": "string"})
+
+	coreParam := openAPIParameter("core", "query", "named solr core to search", false, openAPIObject{"type": "string"})
+	if len(p.config.Solr.Cores) > 0 {
+		var names []string
+		for name := range p.config.Solr.Cores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		coreParam["schema"] = openAPIObject{"type": "string", "enum": names}
+	}
+
+	boolParam := func(name, description string) openAPIObject {
+		return openAPIParameter(name, "query", description, false, openAPIObject{"type": "boolean"})
+	}
+
+	textResponse := func(description string) openAPIObject {
+		return openAPIObject{
+			"description": description,
+			"content":     openAPIObject{"text/plain": openAPIObject{"schema": openAPIObject{"type": "string"}}},
+		}
+	}
+
+	healthSchema := openAPIObject{
+		"type": "object",
+		"additionalProperties": openAPIObject{
+			"type": "object",
+			"properties": openAPIObject{
+				"healthy": openAPIObject{"type": "boolean"},
+				"message": openAPIObject{"type": "string"},
+			},
+		},
+	}
+
+	stringList := stringListSchema()
+
+	paths := openAPIObject{
+		"/version": openAPIObject{
+			"get": openAPIObject{
+				"summary":   "service version information",
+				"responses": openAPIObject{"200": openAPIResponse("version information", openAPIObject{"type": "object"})},
+			},
+		},
+		"/healthcheck": openAPIObject{
+			"get": openAPIObject{
+				"summary": "service and dependency health",
+				"responses": openAPIObject{
+					"200": openAPIResponse("healthy (possibly degraded)", healthSchema),
+					"500": openAPIResponse("a critical dependency is unhealthy", healthSchema),
+				},
+			},
+		},
+		"/openapi.json": openAPIObject{
+			"get": openAPIObject{
+				"summary":   "this document",
+				"responses": openAPIObject{"200": openAPIResponse("openapi document", openAPIObject{"type": "object"})},
+			},
+		},
+		"/api/item/{id}": openAPIObject{
+			"get": openAPIObject{
+				"summary":  "digital content for an item",
+				"security": bearer,
+				"parameters": []openAPIObject{
+					idParam,
+					coreParam,
+					boolParam("countonly", "return only the number of parts"),
+					openAPIParameter("part_type", "query", "return only parts of this type", false, openAPIObject{"type": "string"}),
+					boolParam("pretty", "indent the response (admin only)"),
+					boolParam("meta", "include response metadata"),
+					boolParam("timing", "include a backend timing breakdown (admin only)"),
+				},
+				"responses": openAPIObject{
+					"400": textResponse("invalid item id or core"),
+					"401": openAPIResponse("missing or invalid token", nil),
+					"404": textResponse("item not found"),
+					"503": openAPIResponse("solr is unreachable; see Retry-After", openAPIObject{
+						"type":       "object",
+						"properties": openAPIObject{"error": openAPIObject{"type": "string"}},
+					}),
+				},
+			},
+		},
+		"/api/schema": openAPIObject{
+			"get": openAPIObject{
+				"summary":  "item and part response field names",
+				"security": bearer,
+				"responses": openAPIObject{
+					"200": openAPIResponse("field names", openAPIObject{
+						"type": "object",
+						"properties": openAPIObject{
+							"item":  stringList,
+							"parts": stringList,
+						},
+					}),
+					"401": openAPIResponse("missing or invalid token", nil),
+				},
+			},
+		},
+		"/admin/explain/{id}": openAPIObject{
+			"get": openAPIObject{
+				"summary":    "solr query debug/explain output for an item (admin only)",
+				"security":   bearer,
+				"parameters": []openAPIObject{idParam, coreParam},
+				"responses": openAPIObject{
+					"200": openAPIResponse("query explanation", openAPIObject{
+						"type": "object",
+						"properties": openAPIObject{
+							"found": openAPIObject{"type": "boolean"},
+							"debug": openAPIObject{"type": "object"},
+						},
+					}),
+					"401": openAPIResponse("missing or invalid token", nil),
+					"403": openAPIResponse("not an admin", nil),
+				},
+			},
+		},
+		"/admin/view/{id}": openAPIObject{
+			"get": openAPIObject{
+				"summary":    "html rendering of the item response, for manual verification (admin only)",
+				"security":   bearer,
+				"parameters": []openAPIObject{idParam, coreParam},
+				"responses": openAPIObject{
+					"200": openAPIObject{
+						"description": "item page",
+						"content":     openAPIObject{"text/html": openAPIObject{"schema": openAPIObject{"type": "string"}}},
+					},
+					"401": openAPIResponse("missing or invalid token", nil),
+					"403": openAPIResponse("not an admin", nil),
+					"404": textResponse("item not found"),
+				},
+			},
+		},
+		"/admin/stats": openAPIObject{
+			"get": openAPIObject{
+				"summary":    "request and backend activity since startup or the last reset (admin only)",
+				"security":   bearer,
+				"parameters": []openAPIObject{boolParam("reset", "start a new accumulation period after this snapshot")},
+				"responses": openAPIObject{
+					"200": openAPIResponse("activity snapshot", openAPIObject{"type": "object"}),
+					"401": openAPIResponse("missing or invalid token", nil),
+					"403": openAPIResponse("not an admin", nil),
+				},
+			},
+		},
+		"/admin/raw/{id}": openAPIObject{
+			"get": openAPIObject{
+				"summary":    "raw solr document for an item (admin only)",
+				"security":   bearer,
+				"parameters": []openAPIObject{idParam, coreParam},
+				"responses": openAPIObject{
+					"200": openAPIResponse("raw solr document", openAPIObject{"type": "object"}),
+					"401": openAPIResponse("missing or invalid token", nil),
+					"403": openAPIResponse("not an admin", nil),
+					"404": textResponse("item not found"),
+				},
+			},
+		},
+	}
+
+	itemResponses := paths["/api/item/{id}"].(openAPIObject)["get"].(openAPIObject)["responses"].(openAPIObject)
+
+	itemShapes := []openAPIObject{{"$ref": "#/components/schemas/Item"}, {"$ref": "#/components/schemas/ItemCount"}}
+	if p.config.Fields.Parts.NotAvailableOK == true || (p.features.embargo == true && p.config.Fields.Embargo.Mode == "hide") {
+		itemShapes = append(itemShapes, openAPIObject{"$ref": "#/components/schemas/ItemPlaceholder"})
+	}
+
+	itemResponses["200"] = openAPIResponse("item digital content", openAPIObject{"oneOf": itemShapes})
+
+	itemResponses["500"] = openAPIObject{
+		"description": "backend error (as json, with the individual problems, for admins when there are any)",
+		"content": openAPIObject{
+			"text/plain": openAPIObject{"schema": openAPIObject{"type": "string"}},
+			"application/json": openAPIObject{"schema": openAPIObject{
+				"type": "object",
+				"properties": openAPIObject{
+					"error":    openAPIObject{"type": "string"},
+					"problems": stringListSchema(),
+				},
+			}},
+		},
+	}
+
+	var forbidden []string
+
+	if p.config.Entitlement.Claim != "" {
+		forbidden = append(forbidden, "token lacks digital content entitlement")
+	}
+
+	if p.features.spellcheck == true {
+		itemResponses["404"] = openAPIObject{
+			"description": "item not found (as json, with spelling suggestions, when there are any)",
+			"content": openAPIObject{
+				"text/plain": openAPIObject{"schema": openAPIObject{"type": "string"}},
+				"application/json": openAPIObject{"schema": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"error":       openAPIObject{"type": "string"},
+						"suggestions": stringListSchema(),
+					},
+				}},
+			},
+		}
+	}
+
+	if p.features.embargo == true && p.config.Fields.Embargo.Mode != "hide" {
+		forbidden = append(forbidden, "item is under embargo")
+	}
+
+	if len(forbidden) > 0 {
+		itemResponses["403"] = textResponse(strings.Join(forbidden, ", or "))
+	}
+
+	if p.features.downloadProxy == true {
+		paths["/api/item/{id}/parts/{pid}/pdf/download"] = openAPIObject{
+			"get": openAPIObject{
+				"summary":    "pdf for a part, streamed from the pdf service (supports range requests)",
+				"security":   bearer,
+				"parameters": []openAPIObject{idParam, coreParam, openAPIParameter("pid", "path", "part identifier", true, openAPIObject{"type": "string"})},
+				"responses": openAPIObject{
+					"200": openAPIObject{"description": "pdf", "content": openAPIObject{"application/pdf": openAPIObject{}}},
+					"206": openAPIObject{"description": "requested byte range of the pdf", "content": openAPIObject{"application/pdf": openAPIObject{}}},
+					"401": openAPIResponse("missing or invalid token", nil),
+					"403": textResponse("pdf is restricted"),
+					"404": textResponse("item, part, or pdf not found"),
+					"502": textResponse("pdf service failure"),
+				},
+			},
+		}
+	}
+
+	for _, path := range nonemptyValues(p.config.Server.IgnorePaths) {
+		paths[path] = openAPIObject{
+			"get": openAPIObject{
+				"summary":   "ignored path",
+				"responses": openAPIObject{"default": openAPIResponse("empty response", nil)},
+			},
+		}
+	}
+
+	server := p.config.Server.BasePath
+	if server == "" {
+		server = "/"
+	}
+
+	return openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":   "virgo4-digital-content-ws",
+			"version": p.version.BuildVersion,
+		},
+		"servers": []openAPIObject{{"url": server}},
+		"paths":   paths,
+		"components": openAPIObject{
+			"securitySchemes": openAPIObject{
+				"bearerAuth": openAPIObject{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"schemas": openAPIObject{
+				"Item":            p.openAPIItemSchema(),
+				"ItemCount":       p.openAPIItemCountSchema(),
+				"ItemPlaceholder": p.openAPIItemPlaceholderSchema(),
+				"Part":            p.openAPIPartSchema(),
+			},
+		},
+	}
+}
+
+func (p *serviceContext) openAPIHandler(c *gin.Context) {
+	cl := clientContext{}
+	cl.init(p, c)
+
+	c.JSON(http.StatusOK, p.openAPIDocument())
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+// documentedShape returns the properties of a component schema
+func documentedShape(t *testing.T, doc openAPIObject, name string) openAPIObject {
+	t.Helper()
+
+	schema, ok := doc["components"].(openAPIObject)["schemas"].(openAPIObject)[name].(openAPIObject)
+	if ok == false {
+		t.Fatalf("no %s schema", name)
+	}
+
+	return schema["properties"].(openAPIObject)
+}
+
+func TestOpenAPIItemResponseShapes(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"), map[string]interface{}{"id": "item-2"})
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Parts.Indexed[0].Required = false
+	cfg.Fields.Parts.NotAvailableOK = true
+
+	p := newTestService(t, cfg)
+
+	doc := p.openAPIDocument()
+
+	responses := doc["paths"].(openAPIObject)["/api/item/{id}"].(openAPIObject)["get"].(openAPIObject)["responses"].(openAPIObject)
+
+	// every shape the item endpoint can answer with is offered
+
+	shapes := map[string]bool{}
+	for _, shape := range responses["200"].(openAPIObject)["content"].(openAPIObject)["application/json"].(openAPIObject)["schema"].(openAPIObject)["oneOf"].([]openAPIObject) {
+		shapes[shape["$ref"].(string)] = true
+	}
+
+	for _, name := range []string{"Item", "ItemCount", "ItemPlaceholder"} {
+		if shapes["#/components/schemas/"+name] == false {
+			t.Errorf("200 response does not offer %s: %v", name, shapes)
+		}
+	}
+
+	// and documents each field those responses carry
+
+	s := newTestSearch(p, "/api/item/item-1?countonly=true")
+	s.id = "item-1"
+
+	count := s.handleItemRequest().data.(map[string]interface{})
+	countShape := documentedShape(t, doc, "ItemCount")
+
+	for key := range count {
+		if _, ok := countShape[key]; ok == false {
+			t.Errorf("count response field %s is undocumented", key)
+		}
+	}
+
+	placeholderShape := documentedShape(t, doc, "ItemPlaceholder")
+
+	for key := range testItem(t, p, "item-2") {
+		if _, ok := placeholderShape[key]; ok == false {
+			t.Errorf("placeholder response field %s is undocumented", key)
+		}
+	}
+
+	if _, ok := placeholderShape["embargoed"]; ok == false {
+		t.Errorf("placeholder embargoed field is undocumented")
+	}
+
+	problems := responses["500"].(openAPIObject)["content"].(openAPIObject)["application/json"].(openAPIObject)["schema"].(openAPIObject)["properties"].(openAPIObject)
+	if _, ok := problems["problems"]; ok == false {
+		t.Errorf("500 response does not document admin problems: %v", problems)
+	}
+}
@@ -2,28 +2,92 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
+// pdfBackendError indicates the pdf service itself failed (unreachable, timed out, or
+// returned an unexpected status), as opposed to a pdf simply not existing yet
+type pdfBackendError struct {
+	msg       string
+	retryable bool // transient failure (connection error, 5xx) that may succeed if retried
+}
+
+func (e pdfBackendError) Error() string {
+	return e.msg
+}
+
 func (s *searchContext) getPdfStatus(pdfURL, pid string) (string, error) {
+	retries := 0
+	if s.svc.features.pdfRetries == true {
+		retries = integerWithMinimum(s.svc.config.Pdf.Retries, 0)
+	}
+	backoff := time.Duration(integerWithMinimum(s.svc.config.Pdf.RetryBackoffMS, 0)) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		status, err := s.requestPdfStatus(pdfURL, pid)
+
+		backendErr, ok := err.(pdfBackendError)
+		if err == nil || ok == false || backendErr.retryable == false || attempt >= retries {
+			return status, err
+		}
+
+		// exponential backoff, but never wait past the request deadline (if any)
+
+		wait := backoff << uint(attempt)
+
+		if deadline, ok := s.ctx.Deadline(); ok == true && time.Now().Add(wait).After(deadline) {
+			s.log("[PDF] not retrying status request; request deadline would be exceeded")
+			return status, err
+		}
+
+		if s.takeRetry() == false {
+			return status, err
+		}
+
+		s.log("[PDF] retrying status request in %d ms (retry %d of %d)", int64(wait/time.Millisecond), attempt+1, retries)
+
+		select {
+		case <-s.ctx.Done():
+			return status, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *searchContext) requestPdfStatus(pdfURL, pid string) (string, error) {
 	if pdfURL == "" || pid == "" {
 		return "", fmt.Errorf("pdf url or pid is missing")
 	}
 
-	url := fmt.Sprintf("%s/%s%s", pdfURL, pid, s.svc.config.Pdf.Endpoints.Status)
+	// without a status endpoint there is nothing to ask; report the configured default
+	if s.svc.config.Pdf.Endpoints.Status == "" {
+		return s.svc.config.Pdf.DefaultStatus, nil
+	}
+
+	url := pdfEndpointURL(pdfURL, pid, s.svc.config.Pdf.Endpoints.Status)
+
+	opCtx, cancel := s.operationContext(s.svc.config.Pdf.StatusTimeoutMS)
+	defer cancel()
 
-	req, reqErr := http.NewRequest("GET", url, nil)
+	req, reqErr := http.NewRequestWithContext(opCtx, "GET", url, nil)
 	if reqErr != nil {
 		s.log("[PDF] NewRequest() failed: %s", reqErr.Error())
 		return "", fmt.Errorf("failed to create PDF status request")
 	}
 
+	setRequestHeaders(req, s.svc.config.Pdf.Headers)
+
 	start := time.Now()
 	res, resErr := s.svc.pdf.client.Do(req)
 	elapsedMS := int64(time.Since(start) / time.Millisecond)
+	s.pdfMS = append(s.pdfMS, elapsedMS)
+	s.svc.stats.pdfChecked(elapsedMS, resErr != nil || res.StatusCode != http.StatusOK)
 
 	// external service failure logging
 
@@ -39,8 +103,8 @@ func (s *searchContext) getPdfStatus(pdfURL, pid string) (string, error) {
 		}
 
 		s.log("[PDF] client.Do() failed: %s", resErr.Error())
-		s.log("ERROR: Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, url, status, errMsg, elapsedMS)
-		return "", fmt.Errorf("failed to receive PDF status response")
+		s.err("Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, url, status, errMsg, elapsedMS)
+		return "", pdfBackendError{msg: "failed to receive PDF status response", retryable: s.ctx.Err() == nil}
 	}
 
 	defer res.Body.Close()
@@ -48,8 +112,8 @@ func (s *searchContext) getPdfStatus(pdfURL, pid string) (string, error) {
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
 		errMsg := fmt.Errorf("unexpected status code %d", res.StatusCode)
 		s.log("[PDF] unexpected status code %d", res.StatusCode)
-		s.log("ERROR: Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, url, res.StatusCode, errMsg, elapsedMS)
-		return "", fmt.Errorf("received PDF status response code %d", res.StatusCode)
+		s.err("Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, url, res.StatusCode, errMsg, elapsedMS)
+		return "", pdfBackendError{msg: fmt.Sprintf("received PDF status response code %d", res.StatusCode), retryable: res.StatusCode >= http.StatusInternalServerError}
 	}
 
 	if res.StatusCode == http.StatusNotFound {
@@ -60,7 +124,7 @@ func (s *searchContext) getPdfStatus(pdfURL, pid string) (string, error) {
 
 	if err != nil {
 		s.log("[PDF] error reading pdf status response (%s)", err.Error())
-		return "", fmt.Errorf("error reading pdf status response")
+		return "", pdfBackendError{msg: "error reading pdf status response"}
 	}
 
 	// external service success logging
@@ -69,3 +133,143 @@ func (s *searchContext) getPdfStatus(pdfURL, pid string) (string, error) {
 
 	return string(status), nil
 }
+
+func (s *searchContext) pdfPing() error {
+	url := s.svc.config.Pdf.HealthCheckURL
+
+	req, reqErr := http.NewRequestWithContext(s.ctx, "GET", url, nil)
+	if reqErr != nil {
+		s.log("[PDF] NewRequest() failed: %s", reqErr.Error())
+		return fmt.Errorf("failed to create PDF healthcheck request")
+	}
+
+	setRequestHeaders(req, s.svc.config.Pdf.Headers)
+
+	start := time.Now()
+	res, resErr := s.svc.pdf.client.Do(req)
+	elapsedMS := int64(time.Since(start) / time.Millisecond)
+
+	if resErr != nil {
+		s.log("[PDF] client.Do() failed: %s", resErr.Error())
+		s.log("ERROR: Failed response from %s %s - %s. Elapsed Time: %d (ms)", req.Method, url, resErr.Error(), elapsedMS)
+		return fmt.Errorf("failed to receive PDF healthcheck response")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		s.log("ERROR: Failed response from %s %s - %d. Elapsed Time: %d (ms)", req.Method, url, res.StatusCode, elapsedMS)
+		return fmt.Errorf("received PDF healthcheck response code %d", res.StatusCode)
+	}
+
+	s.log("Successful PDF response from %s %s. Elapsed Time: %d (ms)", req.Method, url, elapsedMS)
+
+	return nil
+}
+
+// headers passed through between the client and the pdf service when proxying a download,
+// so that range (resumable) and conditional requests work end to end
+var pdfDownloadRequestHeaders = []string{"Range", "If-Range", "If-Modified-Since", "If-None-Match"}
+var pdfDownloadResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// partPdfDownloadURL finds the download url constructed for a part during item assembly
+func partPdfDownloadURL(data interface{}, pid string) (string, int, error) {
+	item, _ := data.(map[string]interface{})
+	parts, _ := item["parts"].([]map[string]interface{})
+
+	for _, part := range parts {
+		if part["pid"] != pid {
+			continue
+		}
+
+		pdf, ok := part["pdf"].(map[string]interface{})
+		if ok == false {
+			return "", http.StatusNotFound, fmt.Errorf("no pdf for this part")
+		}
+
+		if pdf["restricted"] == true {
+			return "", http.StatusForbidden, fmt.Errorf("pdf is restricted")
+		}
+
+		urls, _ := pdf["urls"].(map[string]interface{})
+		downloadURL, _ := urls["download"].(string)
+		if downloadURL == "" {
+			return "", http.StatusNotFound, fmt.Errorf("no pdf download url for this part")
+		}
+
+		return downloadURL, http.StatusOK, nil
+	}
+
+	return "", http.StatusNotFound, fmt.Errorf("part not found")
+}
+
+// proxyPdfDownload streams a pdf from the pdf service to the client.  it is bounded by the
+// client's connection rather than the request time budget, since downloads can be large.
+func (s *searchContext) proxyPdfDownload(c *gin.Context, downloadURL string) {
+	req, reqErr := http.NewRequestWithContext(c.Request.Context(), "GET", downloadURL, nil)
+	if reqErr != nil {
+		s.log("[PDF] NewRequest() failed: %s", reqErr.Error())
+		c.String(http.StatusInternalServerError, "failed to create PDF download request")
+		return
+	}
+
+	setRequestHeaders(req, s.svc.config.Pdf.Headers)
+
+	for _, header := range pdfDownloadRequestHeaders {
+		if val := c.GetHeader(header); val != "" {
+			req.Header.Set(header, val)
+		}
+	}
+
+	start := time.Now()
+	res, resErr := s.svc.pdf.download.Do(req)
+	elapsedMS := int64(time.Since(start) / time.Millisecond)
+
+	if resErr != nil {
+		s.log("[PDF] client.Do() failed: %s", resErr.Error())
+		s.err("Failed response from %s %s. Elapsed Time: %d (ms)", req.Method, downloadURL, elapsedMS)
+		c.String(http.StatusBadGateway, "failed to receive PDF download response")
+		return
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+
+	case http.StatusNotFound:
+		s.log("WARNING: PDF does not (yet) exist: %s", downloadURL)
+		c.String(http.StatusNotFound, "pdf does not exist")
+		return
+
+	default:
+		s.err("Failed response from %s %s - %d. Elapsed Time: %d (ms)", req.Method, downloadURL, res.StatusCode, elapsedMS)
+		c.String(http.StatusBadGateway, fmt.Sprintf("received PDF download response code %d", res.StatusCode))
+		return
+	}
+
+	s.log("Successful PDF response from %s %s - %d. Elapsed Time: %d (ms)", req.Method, downloadURL, res.StatusCode, elapsedMS)
+
+	for _, header := range pdfDownloadResponseHeaders {
+		if val := res.Header.Get(header); val != "" {
+			c.Header(header, val)
+		}
+	}
+
+	// the server write timeout is sized for item responses, and would cut off large downloads
+	if rc, ok := c.Request.Context().Value(responseControllerKey{}).(*http.ResponseController); ok == true {
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			s.log("[PDF] could not lift write deadline: %s", err.Error())
+		}
+	}
+
+	c.Status(res.StatusCode)
+
+	written, err := io.Copy(c.Writer, res.Body)
+	if err != nil {
+		s.log("[PDF] download interrupted after %d bytes: %s", written, err.Error())
+		return
+	}
+
+	s.log("[PDF] streamed %d bytes", written)
+}
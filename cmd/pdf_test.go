@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPdfDownloadOutlivesWriteTimeout(t *testing.T) {
+	// a pdf service that takes longer than the write timeout to send the whole file
+	pdfService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 "))
+		w.(http.Flusher).Flush()
+		time.Sleep(750 * time.Millisecond)
+		w.Write([]byte("%%EOF"))
+	}))
+	t.Cleanup(pdfService.Close)
+
+	p := newTestService(t, testConfig("http://solr.invalid"))
+
+	router := gin.New()
+	router.GET("/download", func(c *gin.Context) {
+		s := newTestSearch(p, "/download")
+		s.proxyPdfDownload(c, pdfService.URL)
+	})
+
+	server := httptest.NewUnstartedServer(withResponseController(router))
+	server.Config.WriteTimeout = 250 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	res, err := http.Get(server.URL + "/download")
+	if err != nil {
+		t.Fatalf("download failed: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("download cut off after %d bytes: %s", len(body), err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK || strings.HasSuffix(string(body), "%%EOF") == false {
+		t.Errorf("download = %d %q; want the whole pdf", res.StatusCode, body)
+	}
+}
+
+func TestPdfStatusFailureLoggedUnsampled(t *testing.T) {
+	pdfService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	t.Cleanup(pdfService.Close)
+
+	doc := testDoc("item-1")
+	doc["pdf_url_a"] = []string{pdfService.URL}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := pdfConfig(testConfig(solr.server.URL), nil)
+	cfg.Pdf.Endpoints.Status = "/status"
+	cfg.Log.SampleRate = "0"
+
+	p := newTestService(t, cfg)
+
+	logs := captureLog(t)
+
+	// the item itself succeeds, so nothing else would release the request's held log lines
+	testItem(t, p, "item-1")
+
+	if strings.Contains(logs.String(), "ERROR: Failed response from GET "+pdfService.URL) == false {
+		t.Errorf("pdf status failure not logged for an unsampled request:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyNetwork parses a trusted proxy entry, either a single address or a cidr range
+func proxyNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") == false {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+		}
+	}
+
+	_, network, err := net.ParseCIDR(entry)
+
+	return network, err
+}
+
+func (p *serviceContext) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.trustedProxies {
+		if network.Contains(ip) == true {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustedProxyHandler rewrites the forwarding headers that gin's ClientIP() consults, so
+// that it reports the real client address.  forwarding headers from untrusted peers are
+// discarded, since anyone can send them; otherwise X-Forwarded-For is walked from the
+// nearest hop outward, and the first address that is not a trusted proxy is the client.
+func (p *serviceContext) trustedProxyHandler(c *gin.Context) {
+	header := c.Request.Header
+
+	forwarded := header.Get("X-Forwarded-For")
+
+	header.Del("X-Forwarded-For")
+	header.Del("X-Real-Ip")
+
+	peer, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil || forwarded == "" || p.isTrustedProxy(peer) == false {
+		return
+	}
+
+	hops := strings.Split(forwarded, ",")
+
+	client := strings.TrimSpace(hops[0])
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if p.isTrustedProxy(hop) == false {
+			client = hop
+			break
+		}
+	}
+
+	if net.ParseIP(client) != nil {
+		header.Set("X-Forwarded-For", client)
+	}
+}
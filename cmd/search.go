@@ -1,27 +1,67 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type searchContext struct {
-	svc     *serviceContext
-	client  *clientContext
-	id      string
-	solrReq *solrRequest
-	solrRes *solrResponse
+	svc       *serviceContext
+	client    *clientContext
+	ctx       context.Context // bounds all backend calls made for this request
+	id        string
+	idField   string  // solr field the id is matched against
+	lookupIdx int     // index of the lookup field that matched the id (0 for the primary lookup)
+	core      string  // named solr core requested by the client; empty for the default core
+	degraded  bool    // set when backend calls were skipped or cut short
+	pdfStatus bool    // set when the response includes (volatile) pdf status
+	explain   bool    // set to request solr's query debug/explain output
+	urlsOnly  bool    // set when only part urls are needed; skips live pdf status checks
+	pdfChecks int     // number of live pdf status checks made for this request
+	retries   int     // backend retries remaining for this request; negative if unlimited
+	solrMS    int64   // time spent waiting on solr responses
+	pdfMS     []int64 // time spent waiting on each pdf status response
+	solrReq   *solrRequest
+	solrRes   *solrResponse
 }
 
 type searchResponse struct {
-	status int         // http status code
-	data   interface{} // data to return as JSON
-	err    error       // error, if any
+	status   int         // http status code
+	data     interface{} // data to return as JSON
+	err      error       // error, if any
+	problems []string    // individual problems behind err, if any (shown to admins only)
+	suggest  []string    // spelling suggestions, for ids that matched nothing
 }
 
 func (s *searchContext) init(p *serviceContext, c *clientContext) {
 	s.svc = p
 	s.client = c
+	s.ctx = c.ginCtx.Request.Context()
+
+	s.retries = -1
+	if s.svc.config.Server.RetryBudget != "" {
+		s.retries = integerWithMinimum(s.svc.config.Server.RetryBudget, 0)
+	}
+}
+
+func (s *searchContext) takeRetry() bool {
+	// consumes one retry from the request's shared budget, if any remain
+	if s.retries == 0 {
+		s.log("retry budget exhausted; not retrying")
+		return false
+	}
+
+	if s.retries > 0 {
+		s.retries--
+	}
+
+	return true
 }
 
 func (s *searchContext) log(format string, args ...interface{}) {
@@ -32,24 +72,148 @@ func (s *searchContext) err(format string, args ...interface{}) {
 	s.client.err(format, args...)
 }
 
+func (s *searchContext) validateCore() error {
+	if s.core == "" {
+		return nil
+	}
+
+	if _, ok := s.svc.solr.cores[s.core]; ok == false {
+		return fmt.Errorf("unknown core: %s", s.core)
+	}
+
+	return nil
+}
+
+func (s *searchContext) solrCoreName() string {
+	// the actual solr core that answered, which a named core maps to
+	if s.core != "" {
+		return s.svc.config.Solr.Cores[s.core]
+	}
+
+	return s.svc.config.Solr.Core
+}
+
+func (s *searchContext) solrServiceContext() serviceSolrContext {
+	if s.core != "" {
+		return s.svc.solr.cores[s.core]
+	}
+
+	return s.svc.solr.service
+}
+
+func (s *searchContext) validateID() error {
+	if s.svc.solr.idMaxLength > 0 && len(s.id) > s.svc.solr.idMaxLength {
+		return fmt.Errorf("id exceeds maximum length of %d", s.svc.solr.idMaxLength)
+	}
+
+	if s.svc.solr.idRegex != nil && s.svc.solr.idRegex.MatchString(s.id) == false {
+		return fmt.Errorf("id contains invalid characters")
+	}
+
+	return nil
+}
+
+func (s *searchContext) operationContext(timeoutMS string) (context.Context, context.CancelFunc) {
+	// bounds a single backend operation, in addition to the request budget and client timeouts
+	if timeout := integerWithMinimum(timeoutMS, 0); timeout > 0 {
+		return context.WithTimeout(s.ctx, time.Duration(timeout)*time.Millisecond)
+	}
+
+	return context.WithCancel(s.ctx)
+}
+
+func (s *searchContext) timeBudget() time.Duration {
+	budget := time.Duration(integerWithMinimum(s.svc.config.Server.RequestTimeoutMS, 0)) * time.Millisecond
+
+	// a client deadline can only shorten the budget, and is clamped to the configured maximum
+
+	maxClient := time.Duration(integerWithMinimum(s.svc.config.Server.ClientTimeoutMaxMS, 0)) * time.Millisecond
+
+	if s.svc.features.clientDeadline == true && s.client.timeout > 0 {
+		clientBudget := s.client.timeout
+		if clientBudget > maxClient {
+			clientBudget = maxClient
+		}
+
+		if budget == 0 || clientBudget < budget {
+			s.log("honoring client deadline: %d ms", int64(clientBudget/time.Millisecond))
+			budget = clientBudget
+		}
+	}
+
+	return budget
+}
+
 func (s *searchContext) handleItemRequest() searchResponse {
-	if err := s.solrQuery(); err != nil {
+	// enforce the overall backend time budget, if configured
+
+	if budget := s.timeBudget(); budget > 0 {
+		ctx, cancel := context.WithTimeout(s.ctx, budget)
+		defer cancel()
+		s.ctx = ctx
+	}
+
+	if err := s.validateCore(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	if err := s.validateID(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	if err := s.solrLookup(); err != nil {
 		s.err("query execution error: %s", err.Error())
-		return searchResponse{status: http.StatusInternalServerError, err: err}
+		return searchResponse{status: solrErrorStatus(err), err: err}
+	}
+
+	// freshly indexed records may not be searchable until committed; try real-time get
+
+	if s.solrRes.meta.numRows == 0 && s.svc.features.realtimeGet == true {
+		s.log("record not found by query; trying real-time get")
+
+		if err := s.solrRealTimeGet(); err != nil {
+			s.err("real-time get execution error: %s", err.Error())
+			return searchResponse{status: solrErrorStatus(err), err: err}
+		}
 	}
 
 	if s.solrRes.meta.numRows == 0 {
 		err := fmt.Errorf("record not found")
 		s.err(err.Error())
-		return searchResponse{status: http.StatusNotFound, err: err}
+		return searchResponse{status: http.StatusNotFound, err: err, suggest: s.solrRes.spellingSuggestions()}
 	}
 
 	// verify indexed part field lengths are equal, and all required fields are present
 
 	doc := s.solrRes.Response.Docs[0]
 
+	// embargoed records are not served until their release date
+
+	if s.svc.features.embargo == true {
+		if embargoed, releaseDate := s.isEmbargoed(doc); embargoed == true {
+			if s.svc.config.Fields.Embargo.Mode == "hide" {
+				s.log("record is under embargo until %s; responding with placeholder", releaseDate)
+				placeholder := make(map[string]interface{})
+				placeholder["id"] = doc.ID
+				placeholder["available"] = false
+				placeholder["embargoed"] = true
+				return searchResponse{status: http.StatusOK, data: placeholder}
+			}
+
+			err := fmt.Errorf("record is under embargo")
+			s.err("%s until %s", err.Error(), releaseDate)
+			return searchResponse{status: http.StatusForbidden, err: err}
+		}
+	}
+
 	length := -1
+	shortest := -1
+	longest := -1
+	mismatch := false
 	invalid := false
+	var problems []string
 
 	for _, field := range s.svc.config.Fields.Parts.Indexed {
 		fieldValues := doc.getValuesByTag(field.Field)
@@ -58,49 +222,120 @@ func (s *searchContext) handleItemRequest() searchResponse {
 		if field.Required == true && fieldLength == 0 {
 			err := fmt.Errorf("missing required digital content field: %s", field.Field)
 			s.err(err.Error())
+			problems = append(problems, err.Error())
 			invalid = true
 			continue
 		}
 
 		s.log("%d = len(%s)", fieldLength, field.Field)
 
+		if fieldLength != 0 {
+			if shortest == -1 || fieldLength < shortest {
+				shortest = fieldLength
+			}
+
+			if fieldLength > longest {
+				longest = fieldLength
+			}
+		}
+
 		if length == -1 {
 			length = fieldLength
 			continue
 		}
 
 		if fieldLength != 0 && fieldLength != length {
-			err := fmt.Errorf("array-type field length mismatch for field: %s", field.Field)
+			err := fmt.Errorf("array-type field length mismatch for field: %s (%d != %d)", field.Field, fieldLength, length)
 			s.err(err.Error())
-			invalid = true
+			problems = append(problems, err.Error())
+			mismatch = true
 			continue
 		}
 	}
 
+	// resolve length mismatches according to the configured mode
+
+	if mismatch == true {
+		switch s.svc.config.Fields.Parts.OnMismatch {
+		case "truncate":
+			s.log("field length mismatch; truncating parts to shortest length: %d", shortest)
+			length = shortest
+
+		case "pad":
+			s.log("field length mismatch; padding parts to longest length: %d", longest)
+			length = longest
+
+		default:
+			s.log("field length mismatch; failing request")
+			invalid = true
+		}
+	}
+
 	if invalid == true {
 		err := fmt.Errorf("digital content field inconsistencies")
 		s.err(err.Error())
-		return searchResponse{status: http.StatusInternalServerError, err: err}
+		return searchResponse{status: http.StatusInternalServerError, err: err, problems: problems}
 	}
 
-	if length == 0 {
+	if length == 0 && s.svc.config.Fields.Parts.NotAvailableOK == true {
+		s.log("no digital parts found in this record; responding with placeholder")
+		placeholder := make(map[string]interface{})
+		placeholder["id"] = doc.ID
+		placeholder["available"] = false
+		return searchResponse{status: http.StatusOK, data: placeholder}
+	}
+
+	minParts := s.minParts(doc)
+
+	if length == 0 && minParts > 0 {
 		err := fmt.Errorf("no digital parts found in this record")
 		s.err(err.Error())
 		return searchResponse{status: http.StatusInternalServerError, err: err}
 	}
 
+	if length < minParts {
+		err := fmt.Errorf("too few digital parts found in this record (%d < %d)", length, minParts)
+		s.err(err.Error())
+		return searchResponse{status: http.StatusInternalServerError, err: err}
+	}
+
+	// count-only requests skip part assembly (and the associated pdf status checks)
+
+	if s.client.opts.countOnly == true {
+		count := make(map[string]interface{})
+		count["id"] = doc.ID
+		count["part_count"] = length
+		return searchResponse{status: http.StatusOK, data: count}
+	}
+
 	// build response object
 
-	var parts []map[string]interface{}
+	parts := []map[string]interface{}{}
+
+	// positions[j] is the solr array index that parts[j] was built from
+
+	positions := []int{}
+
+	// repeated pids indicate an indexing problem; their parts would be indistinguishable
+
+	duplicates := s.duplicatePidPositions(doc, length)
+
+	// padding fills the values missing from shorter fields with empty values
+	padding := mismatch == true && s.svc.config.Fields.Parts.OnMismatch == "pad"
 
 	// assign part-level fields
 
 	for i := 0; i < length; i++ {
+		if duplicates[i] == true && s.svc.config.Fields.Parts.OnDuplicatePid == "dedupe" {
+			s.log("dropping part %d with duplicate pid", i+1)
+			continue
+		}
+
 		part := make(map[string]interface{})
 
 		for _, field := range s.svc.config.Fields.Parts.Indexed {
-			fieldValues := doc.getValuesByTag(field.Field)
-			// field will have len() of either 0 or length
+			fieldValues := normalizeValues(doc.getValuesByTag(field.Field), field)
+			// field will have len() of either 0 or length, unless truncated/padded due to a mismatch
 			prefix := field.DefaultPrefix
 			if prefix == "" {
 				prefix = "Item"
@@ -108,8 +343,17 @@ func (s *searchContext) handleItemRequest() searchResponse {
 
 			part[field.Name] = fmt.Sprintf("%s %d", prefix, i+1)
 
-			if len(fieldValues) > 0 {
-				if val := fieldValues[i]; val != "" {
+			if padding == true && len(fieldValues) > 0 && i >= len(fieldValues) {
+				part[field.Name] = ""
+			}
+
+			// parts without a thumbnail get the configured default, if any
+			if field.Thumbnail == true && s.svc.config.Fields.Parts.DefaultThumbnailURL != "" {
+				part[field.Name] = s.svc.config.Fields.Parts.DefaultThumbnailURL
+			}
+
+			if i < len(fieldValues) {
+				if val := s.checkURL(field, fieldValues[i]); val != "" {
 					part[field.Name] = val
 				}
 			}
@@ -121,17 +365,62 @@ func (s *searchContext) handleItemRequest() searchResponse {
 			fieldValues := doc.getValuesByTag(field.Field)
 
 			switch field.Name {
+			case "sequence", "digest":
+				// computed below, once parts are deduplicated, filtered, and sorted
+				continue
+
 			case "iiif_manifest_url":
+				// only iiif-enabled parts (those with a value in the configured field) get a manifest url
+				if partElementOf(fieldValues, i, length) == "" {
+					s.log("no iiif manifest for part %d; skipping iiif manifest url", i+1)
+					continue
+				}
+
 				pid := part["pid"].(string)
-				val = fmt.Sprintf("%s/%s", field.CustomInfo.IIIFManifestURL.URLPrefix, pid)
+				if pid == "" {
+					s.log("no pid; skipping iiif manifest url")
+					continue
+				}
+
+				manifestURL := fmt.Sprintf("%s/%s", field.CustomInfo.IIIFManifestURL.URLPrefix, pid)
+
+				if s.svc.features.iiifCheck == true && s.iiifManifestReachable(manifestURL) == false {
+					s.log("iiif manifest for part %d is not available; skipping iiif manifest url", i+1)
+					continue
+				}
+
+				val = manifestURL
+
+			case "thumbnails":
+				pid := part["pid"].(string)
+				if pid == "" {
+					s.log("no pid; skipping thumbnails")
+					continue
+				}
+
+				thumbnails := field.CustomInfo.Thumbnails
+
+				urls := make(map[string]interface{})
+				for name, size := range thumbnails.Sizes {
+					urls[name] = fmt.Sprintf("%s/%s/full/%s/0/default.jpg", strings.TrimRight(thumbnails.URLPrefix, "/"), pid, size)
+				}
+
+				val = urls
 
 			case "pdf":
-				pdfURL := firstElementOf(fieldValues)
+				pdfURL := s.checkURL(field, firstElementOf(fieldValues))
 				if pdfURL == "" {
 					s.log("no pdf url; skipping pdf section")
 					continue
 				}
 
+				// pdf urls come from the index, so are not covered by startup validation; the
+				// service contacts them (and may proxy their content), so hold them to the same rule
+				if s.svc.config.RequireTLS == true && strings.HasPrefix(strings.ToLower(pdfURL), "https://") == false {
+					s.err("pdf url is not https; skipping pdf section: [%s]", pdfURL)
+					continue
+				}
+
 				pid := part["pid"].(string)
 				if pid == "" {
 					s.log("no pid; skipping pdf section")
@@ -142,18 +431,65 @@ func (s *searchContext) handleItemRequest() searchResponse {
 
 				pdf := make(map[string]interface{})
 
-				pdfStatus, pdfErr := s.getPdfStatus(pdfURL, pid)
-				if pdfErr != nil {
-					pdfStatus = ""
+				// rights-restricted parts only expose the rights wrapper url, if any
+
+				if s.isPdfRestricted(doc, field, i, length) == true {
+					urls := make(map[string]interface{})
+
+					rights := field.CustomInfo.Pdf
+					if wrapperURL := s.checkURL(field, partElementOf(doc.getValuesByTag(rights.RightsWrapperField), i, length)); wrapperURL != "" {
+						urls["rights_wrapper"] = wrapperURL
+					}
+
+					pdf["restricted"] = true
+					pdf["urls"] = urls
+
+					part[field.Name] = pdf
+					continue
+				}
+
+				pdfStatus := ""
+				s.pdfStatus = true
+
+				maxChecks := integerWithMinimum(s.svc.config.Pdf.MaxStatusChecks, 0)
+
+				if s.urlsOnly == true {
+					s.log("only urls needed; skipping pdf status check for part %d", i+1)
+				} else if s.ctx.Err() != nil {
+					s.log("request time budget exhausted; skipping pdf status check")
+					s.degraded = true
+				} else if maxChecks > 0 && s.pdfChecks >= maxChecks {
+					s.log("pdf status check limit (%d) reached; skipping pdf status check for part %d", maxChecks, i+1)
+					pdfStatus = "unknown"
+					pdf["status_skipped"] = true
+				} else {
+					s.pdfChecks++
+
+					var pdfErr error
+					if pdfStatus, pdfErr = s.getPdfStatus(pdfURL, pid); pdfErr != nil {
+						pdfStatus = ""
+						if s.ctx.Err() != nil {
+							s.degraded = true
+						}
+
+						// let clients distinguish a pdf service failure from a pdf that is not yet generated
+						if _, ok := pdfErr.(pdfBackendError); ok == true {
+							pdf["status_error"] = true
+						}
+					}
 				}
 
 				urls := make(map[string]interface{})
-				urls["generate"] = fmt.Sprintf("%s/%s%s", pdfURL, pid, s.svc.config.Pdf.Endpoints.Generate)
-				urls["status"] = fmt.Sprintf("%s/%s%s", pdfURL, pid, s.svc.config.Pdf.Endpoints.Status)
-				urls["download"] = fmt.Sprintf("%s/%s%s", pdfURL, pid, s.svc.config.Pdf.Endpoints.Download)
-				urls["delete"] = fmt.Sprintf("%s/%s%s", pdfURL, pid, s.svc.config.Pdf.Endpoints.Delete)
+				urls["generate"] = pdfEndpointURL(pdfURL, pid, s.svc.config.Pdf.Endpoints.Generate)
+				if s.svc.config.Pdf.Endpoints.Status != "" {
+					urls["status"] = pdfEndpointURL(pdfURL, pid, s.svc.config.Pdf.Endpoints.Status)
+				}
+				urls["download"] = pdfEndpointURL(pdfURL, pid, s.svc.config.Pdf.Endpoints.Download)
+				urls["delete"] = pdfEndpointURL(pdfURL, pid, s.svc.config.Pdf.Endpoints.Delete)
 
 				pdf["status"] = pdfStatus
+				pdf["normalized_status"] = s.normalizedPdfStatus(pdfStatus)
+				pdf["needs_generation"] = pdf["normalized_status"] == "missing"
 				pdf["urls"] = urls
 
 				val = pdf
@@ -164,25 +500,480 @@ func (s *searchContext) handleItemRequest() searchResponse {
 			}
 		}
 
+		if pid, _ := part["pid"].(string); pid != "" && s.svc.features.selfLinks == true {
+			part["self"] = s.partSelfLink(doc.ID, pid)
+		}
+
 		parts = append(parts, part)
+		positions = append(positions, i)
 	}
 
 	item := make(map[string]interface{})
 
 	// assign item-level fields
 
+	// multi-valued field name -> original number of values, for fields that were capped
+	truncated := make(map[string]int)
+
 	for _, field := range s.svc.config.Fields.Item {
-		fieldValues := doc.getValuesByTag(field.Field)
+		fieldValues := normalizeValues(doc.getValuesByTag(s.localizedField(doc, field)), field)
+
+		if field.ValidateURL != "" {
+			// copy, rather than modify the document's own values
+			checked := []string{}
+			for _, val := range fieldValues {
+				checked = append(checked, s.checkURL(field, val))
+			}
+			fieldValues = checked
+		}
+
+		if field.MultiValued == true {
+			vals := nonemptyValues(fieldValues)
+
+			// pathological records can carry dozens of values; report how many there really were
+			if max := integerWithMinimum(field.MaxValues, 0); max > 0 && len(vals) > max {
+				s.log("truncating %s from %d to %d values", field.Name, len(vals), max)
+				truncated[field.Name] = len(vals)
+				vals = vals[:max]
+			}
+
+			if len(vals) > 0 {
+				item[field.Name] = vals
+			}
+			continue
+		}
+
 		if val := firstElementOf(fieldValues); val != "" {
 			item[field.Name] = val
 		}
 	}
 
+	// the parts the record has, before any filtering for this request
+	present := len(parts)
+
+	// filter parts by type, if requested
+
+	if s.client.opts.partType != "" && s.svc.config.Fields.Parts.TypeField != "" {
+		parts, positions = s.filterPartsByType(doc, parts, positions, length)
+	}
+
+	// reorder parts by their sort key, if configured
+
+	if s.svc.config.Fields.Parts.SortField != "" {
+		parts = s.sortParts(doc, parts, positions, length)
+	}
+
+	// number parts by their final position.  the digest is taken first, so that it reflects
+	// the part's content rather than where sorting or filtering happened to put it
+
+	for i, part := range parts {
+		if field := s.svc.partsCustomField("digest"); field != nil {
+			part[field.Name] = partDigest(part)
+		}
+
+		if field := s.svc.partsCustomField("sequence"); field != nil {
+			part[field.Name] = i + 1
+		}
+
+		if s.svc.config.Fields.EmitEmpty == true {
+			emitEmptyFields(part, s.svc.config.Fields.Parts.Indexed)
+			emitEmptyFields(part, s.svc.config.Fields.Parts.Custom)
+		}
+	}
+
 	item["parts"] = parts
 
+	// assign item-level fields derived from config, item values, and assembled parts
+
+	for _, field := range s.svc.config.Fields.Custom {
+		switch field.Name {
+		case "has_downloadable_pdf":
+			item[field.Name] = s.hasDownloadablePdf(parts)
+
+		case "oai_identifier":
+			item[field.Name] = fmt.Sprintf("oai:%s:%s", field.CustomInfo.OAIIdentifier.RepositoryID, doc.ID)
+
+		case "sprite_url":
+			item[field.Name] = fmt.Sprintf("%s/%s", field.CustomInfo.SpriteURL.URLPrefix, doc.ID)
+
+		case "iiif_collection_url":
+			// a collection manifest only adds anything over the part manifest for multi-part records
+			if len(parts) > 1 {
+				item[field.Name] = fmt.Sprintf("%s/%s", field.CustomInfo.IIIFCollectionURL.URLPrefix, doc.ID)
+			}
+
+		case "digitization":
+			// compares against all parts in the record, regardless of any part_type filtering
+			expected := integerWithMinimum(firstElementOf(doc.getValuesByTag(field.Field)), 0)
+			if expected == 0 {
+				s.log("no expected part count; skipping %s", field.Name)
+				continue
+			}
+
+			digitization := make(map[string]interface{})
+			digitization["expected"] = expected
+			digitization["present"] = present
+			digitization["complete"] = present >= expected
+
+			item[field.Name] = digitization
+		}
+	}
+
+	if s.svc.config.Fields.EmitEmpty == true {
+		emitEmptyFields(item, s.svc.config.Fields.Item)
+		emitEmptyFields(item, s.svc.config.Fields.Custom)
+	}
+
+	if s.svc.features.selfLinks == true {
+		item["self"] = s.selfLink(doc.ID)
+	}
+
+	if snippets := s.solrRes.Highlighting[doc.ID]; len(snippets) > 0 {
+		item["highlighting"] = snippets
+	}
+
+	if len(truncated) > 0 {
+		item["truncated"] = truncated
+	}
+
+	// lets clients replace stale identifiers with the canonical one
+	if s.lookupIdx > 0 {
+		resolved := make(map[string]interface{})
+		resolved["id"] = doc.ID
+		resolved["matched_by"] = s.idField
+		item["resolved"] = resolved
+	}
+
+	if s.degraded == true {
+		item["degraded"] = true
+	}
+
+	if s.client.opts.meta == true {
+		meta := make(map[string]interface{})
+		meta["core"] = s.solrCoreName()
+		item["meta"] = meta
+	}
+
+	if s.client.opts.timing == true {
+		item["timing"] = s.timingBreakdown()
+	}
+
 	return searchResponse{status: http.StatusOK, data: item}
 }
 
+func emitEmptyFields(values map[string]interface{}, fields []serviceConfigField) {
+	// gives clients a stable key set, whatever each record happens to contain
+	for _, field := range fields {
+		if _, ok := values[field.Name]; ok == true {
+			continue
+		}
+
+		if field.MultiValued == true {
+			values[field.Name] = []string{}
+			continue
+		}
+
+		values[field.Name] = nil
+	}
+}
+
+func (s *searchContext) checkURL(field serviceConfigField, val string) string {
+	// catches index corruption where a url field holds something other than a url
+	if field.ValidateURL == "" || val == "" {
+		return val
+	}
+
+	if u, err := url.Parse(val); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return val
+	}
+
+	s.log("WARNING: %s value is not a valid url: [%s]", field.Name, val)
+
+	if field.ValidateURL == "drop" {
+		return ""
+	}
+
+	return val
+}
+
+func (s *searchContext) timingBreakdown() map[string]interface{} {
+	// whatever time was not spent waiting on backends was spent assembling the response
+	totalMS := int64(time.Since(s.client.start) / time.Millisecond)
+
+	pdfMS := []int64{}
+	pdfTotalMS := int64(0)
+	for _, ms := range s.pdfMS {
+		pdfMS = append(pdfMS, ms)
+		pdfTotalMS += ms
+	}
+
+	assemblyMS := totalMS - s.solrMS - pdfTotalMS
+	if assemblyMS < 0 {
+		assemblyMS = 0
+	}
+
+	timing := make(map[string]interface{})
+	timing["total_ms"] = totalMS
+	timing["solr_ms"] = s.solrMS
+	timing["pdf_ms"] = pdfMS
+	timing["pdf_total_ms"] = pdfTotalMS
+	timing["assembly_ms"] = assemblyMS
+
+	return timing
+}
+
+func (s *searchContext) minParts(doc solrDocument) int {
+	parts := s.svc.config.Fields.Parts
+
+	// the first collection with a configured minimum wins
+	if parts.CollectionField != "" {
+		for _, collection := range doc.getValuesByTag(parts.CollectionField) {
+			if min, ok := parts.CollectionMinParts[collection]; ok == true {
+				return integerWithMinimum(min, 0)
+			}
+		}
+	}
+
+	if parts.MinParts == "" {
+		return 1
+	}
+
+	return integerWithMinimum(parts.MinParts, 0)
+}
+
+func (s *searchContext) selfLink(id string) string {
+	link := fmt.Sprintf("%s%s/api/item/%s", s.svc.config.Server.PublicBaseURL, s.svc.config.Server.BasePath, url.PathEscape(id))
+
+	// links to records in a named core must select that core again
+	if s.core != "" {
+		link = fmt.Sprintf("%s?core=%s", link, url.QueryEscape(s.core))
+	}
+
+	return link
+}
+
+func (s *searchContext) partSelfLink(id string, pid string) string {
+	// parts have no endpoint of their own; their link is to their place in the item response
+	return fmt.Sprintf("%s#%s", s.selfLink(id), url.PathEscape(pid))
+}
+
+func (s *searchContext) isEmbargoed(doc solrDocument) (bool, string) {
+	releaseDate := firstElementOf(doc.getValuesByTag(s.svc.config.Fields.Embargo.Field))
+	if releaseDate == "" {
+		return false, ""
+	}
+
+	release, err := time.Parse(time.RFC3339, releaseDate)
+	if err != nil {
+		// fail closed: an unparseable release date could hide a real embargo
+		s.log("WARNING: unable to parse embargo release date [%s]: %s", releaseDate, err.Error())
+		return true, releaseDate
+	}
+
+	return time.Now().Before(release), releaseDate
+}
+
+func (s *searchContext) localizedField(doc solrDocument, field serviceConfigField) string {
+	// select the solr field for the client's most preferred language having a value,
+	// trying the full tag first and then its primary subtag (e.g. "fr-ca", then "fr")
+	if len(field.Languages) == 0 {
+		return field.Field
+	}
+
+	for _, lang := range s.client.languages {
+		for _, tag := range []string{lang, strings.Split(lang, "-")[0]} {
+			if langField, ok := field.Languages[tag]; ok == true && len(nonemptyValues(doc.getValuesByTag(langField))) > 0 {
+				return langField
+			}
+		}
+	}
+
+	return field.Field
+}
+
+func (s *searchContext) filterPartsByType(doc solrDocument, parts []map[string]interface{}, positions []int, length int) ([]map[string]interface{}, []int) {
+	typeValues := doc.getValuesByTag(s.svc.config.Fields.Parts.TypeField)
+
+	filtered := []map[string]interface{}{}
+	filteredPositions := []int{}
+
+	for i, part := range parts {
+		partType := partElementOf(typeValues, positions[i], length)
+
+		if partType == s.client.opts.partType || (partType == "" && s.svc.config.Fields.Parts.IncludeUntyped == true) {
+			filtered = append(filtered, part)
+			filteredPositions = append(filteredPositions, positions[i])
+		}
+	}
+
+	s.log("part_type %s: %d of %d parts match", s.client.opts.partType, len(filtered), len(parts))
+
+	return filtered, filteredPositions
+}
+
+// duplicatePidPositions returns the solr array indexes whose pid repeats that of an earlier part
+func (s *searchContext) duplicatePidPositions(doc solrDocument, length int) map[int]bool {
+	duplicates := make(map[int]bool)
+
+	for _, field := range s.svc.config.Fields.Parts.Indexed {
+		if field.Name != "pid" {
+			continue
+		}
+
+		pids := normalizeValues(doc.getValuesByTag(field.Field), field)
+		seen := make(map[string]int)
+
+		for i := 0; i < length && i < len(pids); i++ {
+			if pids[i] == "" {
+				continue
+			}
+
+			if first, ok := seen[pids[i]]; ok == true {
+				s.err("duplicate pid [%s]: part %d repeats part %d", pids[i], i+1, first+1)
+				duplicates[i] = true
+				continue
+			}
+
+			seen[pids[i]] = i
+		}
+	}
+
+	return duplicates
+}
+
+func (s *searchContext) sortParts(doc solrDocument, parts []map[string]interface{}, positions []int, length int) []map[string]interface{} {
+	sortValues := doc.getValuesByTag(s.svc.config.Fields.Parts.SortField)
+
+	type sortablePart struct {
+		part  map[string]interface{}
+		key   float64
+		valid bool // parts with missing or non-numeric keys sort last, in their original order
+	}
+
+	sortable := []sortablePart{}
+
+	for i, part := range parts {
+		key, err := strconv.ParseFloat(strings.TrimSpace(partElementOf(sortValues, positions[i], length)), 64)
+		sortable = append(sortable, sortablePart{part: part, key: key, valid: err == nil})
+	}
+
+	sort.SliceStable(sortable, func(i, j int) bool {
+		if sortable[i].valid == false || sortable[j].valid == false {
+			return sortable[i].valid == true && sortable[j].valid == false
+		}
+
+		return sortable[i].key < sortable[j].key
+	})
+
+	sorted := []map[string]interface{}{}
+
+	for _, part := range sortable {
+		sorted = append(sorted, part.part)
+	}
+
+	return sorted
+}
+
+func (s *searchContext) normalizedPdfStatus(status string) string {
+	// map raw pdf service statuses to our own vocabulary; anything unmapped is "unknown"
+	if normalized, ok := s.svc.config.Pdf.StatusMap[status]; ok == true {
+		return normalized
+	}
+
+	return "unknown"
+}
+
+func (s *searchContext) hasDownloadablePdf(parts []map[string]interface{}) bool {
+	for _, part := range parts {
+		pdf, ok := part["pdf"].(map[string]interface{})
+		if ok == false {
+			continue
+		}
+
+		if status, ok := pdf["status"].(string); ok == true && s.normalizedPdfStatus(status) == "ready" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *searchContext) isPdfRestricted(doc solrDocument, field serviceConfigField, i int, length int) bool {
+	if field.CustomInfo == nil || field.CustomInfo.Pdf == nil {
+		return false
+	}
+
+	rights := field.CustomInfo.Pdf
+
+	vals := doc.getValuesByTag(rights.RightsField)
+
+	// multiple values that don't parallel the parts can't say which parts they cover, so fail closed
+	if len(vals) > 1 && len(vals) != length {
+		s.err("pdf rights field %s has %d values for %d parts; treating part %d as restricted", rights.RightsField, len(vals), length, i+1)
+		return true
+	}
+
+	val := partElementOf(vals, i, length)
+
+	if len(rights.RightsValues) == 0 {
+		return val != ""
+	}
+
+	return sliceContains(rights.RightsValues, val)
+}
+
+func (s *searchContext) handleRawRequest() searchResponse {
+	if err := s.validateCore(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	if err := s.validateID(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	if err := s.solrLookup(); err != nil {
+		s.err("query execution error: %s", err.Error())
+		return searchResponse{status: solrErrorStatus(err), err: err}
+	}
+
+	if s.solrRes.meta.numRows == 0 {
+		err := fmt.Errorf("record not found")
+		s.err(err.Error())
+		return searchResponse{status: http.StatusNotFound, err: err}
+	}
+
+	return searchResponse{status: http.StatusOK, data: s.solrRes.Response.Docs[0]}
+}
+
+func (s *searchContext) handleExplainRequest() searchResponse {
+	if err := s.validateCore(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	if err := s.validateID(); err != nil {
+		s.err(err.Error())
+		return searchResponse{status: http.StatusBadRequest, err: err}
+	}
+
+	s.explain = true
+
+	if err := s.solrLookup(); err != nil {
+		s.err("query execution error: %s", err.Error())
+		return searchResponse{status: solrErrorStatus(err), err: err}
+	}
+
+	// a non-matching query is still worth explaining, so there is no 404 here
+
+	explain := make(map[string]interface{})
+	explain["found"] = s.solrRes.meta.numRows > 0
+	explain["debug"] = s.solrRes.Debug
+
+	return searchResponse{status: http.StatusOK, data: explain}
+}
+
 func (s *searchContext) handlePingRequest() searchResponse {
 	if err := s.solrPing(); err != nil {
 		s.err("query execution error: %s", err.Error())
@@ -191,3 +982,12 @@ func (s *searchContext) handlePingRequest() searchResponse {
 
 	return searchResponse{status: http.StatusOK}
 }
+
+func (s *searchContext) handlePdfPingRequest() searchResponse {
+	if err := s.pdfPing(); err != nil {
+		s.err("pdf healthcheck error: %s", err.Error())
+		return searchResponse{status: http.StatusInternalServerError, err: err}
+	}
+
+	return searchResponse{status: http.StatusOK}
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateIDMatchesWholeID(t *testing.T) {
+	cfg := testConfig("http://solr.invalid")
+	cfg.Solr.IDPattern = "[a-z]+-[0-9]+|uva-lib:[0-9]+"
+
+	p := newTestService(t, cfg)
+
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"item-1", true},
+		{"uva-lib:12345", true},
+		{"item-1 OR id:*", false},
+		{"x item-1", false},
+		{"uva-lib:12345x", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		s := newTestSearch(p, "/api/item/x")
+		s.id = test.id
+
+		if err := s.validateID(); (err == nil) != test.valid {
+			t.Errorf("validateID(%q) = %v; want valid = %v", test.id, err, test.valid)
+		}
+	}
+}
+
+func TestSelfLinks(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item 1"))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Server.PublicBaseURL = "https://content.example.org/"
+	cfg.Server.BasePath = "/v4/"
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item 1")
+
+	if self := item["self"]; self != "https://content.example.org/v4/api/item/item%201" {
+		t.Errorf("item self = %v", self)
+	}
+
+	for i, part := range testParts(t, item) {
+		want := fmt.Sprintf("https://content.example.org/v4/api/item/item%%201#item%%201-p%d", i+1)
+		if part["self"] != want {
+			t.Errorf("part %d self = %v; want %s", i+1, part["self"], want)
+		}
+	}
+}
+
+func TestSelfLinksDisabled(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	item := testItem(t, p, "item-1")
+
+	if _, ok := item["self"]; ok == true {
+		t.Errorf("item has a self link without a public base url")
+	}
+
+	if _, ok := testParts(t, item)[0]["self"]; ok == true {
+		t.Errorf("part has a self link without a public base url")
+	}
+}
+
+func TestDuplicatePidSequence(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["alternate_id_a"] = []string{"item-1-p1", "item-1-p1", "item-1-p2"}
+
+	solr := newFakeSolr(t, doc)
+
+	for _, mode := range []string{"warn", "dedupe"} {
+		cfg := testConfig(solr.server.URL)
+		cfg.Fields.Parts.OnDuplicatePid = mode
+
+		p := newTestService(t, cfg)
+
+		parts := testParts(t, testItem(t, p, "item-1"))
+
+		wantPids := []string{"item-1-p1", "item-1-p1", "item-1-p2"}
+		if mode == "dedupe" {
+			wantPids = []string{"item-1-p1", "item-1-p2"}
+		}
+
+		if len(parts) != len(wantPids) {
+			t.Fatalf("%s: parts = %d; want %d", mode, len(parts), len(wantPids))
+		}
+
+		for i, part := range parts {
+			if part["pid"] != wantPids[i] || part["sequence"] != i+1 {
+				t.Errorf("%s: part %d = pid %v, sequence %v; want pid %s, sequence %d", mode, i, part["pid"], part["sequence"], wantPids[i], i+1)
+			}
+		}
+	}
+}
+
+func TestSortedPartSequence(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["part_sort_key_a"] = []string{"3", "1", "2"}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Parts.SortField = "part_sort_key_a"
+	cfg.Fields.Parts.Custom = append(cfg.Fields.Parts.Custom, serviceConfigField{Name: "digest"})
+
+	p := newTestService(t, cfg)
+
+	parts := testParts(t, testItem(t, p, "item-1"))
+
+	wantPids := []string{"item-1-p2", "item-1-p3", "item-1-p1"}
+
+	for i, part := range parts {
+		if part["pid"] != wantPids[i] || part["sequence"] != i+1 {
+			t.Errorf("part %d = pid %v, sequence %v; want pid %s, sequence %d", i, part["pid"], part["sequence"], wantPids[i], i+1)
+		}
+
+		// the digest covers the part's content, not its sequence or self link
+		content := make(map[string]interface{})
+		for key, val := range part {
+			switch key {
+			case "digest", "sequence", "self":
+			default:
+				content[key] = val
+			}
+		}
+
+		if part["digest"] != partDigest(content) {
+			t.Errorf("part %d digest does not match its content", i)
+		}
+	}
+
+	// parts keep their digests when renumbered, and when given self links
+
+	cfg.Fields.Parts.SortField = ""
+	cfg.Server.PublicBaseURL = "https://content.example.org"
+
+	p = newTestService(t, cfg)
+
+	digests := make(map[interface{}]interface{})
+	for _, part := range parts {
+		digests[part["pid"]] = part["digest"]
+	}
+
+	for i, part := range testParts(t, testItem(t, p, "item-1")) {
+		if part["digest"] != digests[part["pid"]] {
+			t.Errorf("unsorted part %d (%v) digest = %v; want %v, as when sorted", i+1, part["pid"], part["digest"], digests[part["pid"]])
+		}
+	}
+}
+
+func TestMaxValues(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["digital_collection_f"] = []string{"a", "b", "", "c", "d"}
+	doc["thumbnail_url_a"] = []string{"https://iiif.example.org/1"}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item,
+		serviceConfigField{Name: "collections", Field: "digital_collection_f", MultiValued: true, MaxValues: "2"},
+		serviceConfigField{Name: "thumbnails", Field: "thumbnail_url_a", MultiValued: true, MaxValues: "2"},
+	)
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	if got := item["collections"]; reflect.DeepEqual(got, []string{"a", "b"}) == false {
+		t.Errorf("collections = %v; want the first two values", got)
+	}
+
+	if got := item["thumbnails"]; reflect.DeepEqual(got, []string{"https://iiif.example.org/1"}) == false {
+		t.Errorf("thumbnails = %v; want the single value", got)
+	}
+
+	// only capped fields are reported, with their original count of (nonempty) values
+	if got := item["truncated"]; reflect.DeepEqual(got, map[string]int{"collections": 4}) == false {
+		t.Errorf("truncated = %v; want collections: 4", got)
+	}
+}
+
+func TestMaxValuesNotTruncated(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["digital_collection_f"] = []string{"a", "b"}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item, serviceConfigField{Name: "collections", Field: "digital_collection_f", MultiValued: true, MaxValues: "2"})
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	if _, ok := item["truncated"]; ok == true {
+		t.Errorf("truncated reported for a field within its cap: %v", item["truncated"])
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	p := newTestService(t, testConfig("http://solr.invalid"))
+
+	tests := []struct {
+		val   string
+		valid bool
+	}{
+		{"https://iiif.example.org/image/1", true},
+		{"http://iiif.example.org:8080/image/1?size=full", true},
+		{"/image/1", false},
+		{"image/1.jpg", false},
+		{"iiif.example.org/image/1", false},
+		{"//iiif.example.org/image/1", false},
+		{"https://", false},
+		{"ftp://files.example.org/1", false},
+		{"not a url", false},
+	}
+
+	for _, mode := range []string{"", "warn", "drop"} {
+		field := serviceConfigField{Name: "thumbnail", ValidateURL: mode}
+
+		for _, test := range tests {
+			logged := captureLog(t)
+
+			s := newTestSearch(p, "/api/item/item-1")
+
+			got := s.checkURL(field, test.val)
+
+			want := test.val
+			if mode == "drop" && test.valid == false {
+				want = ""
+			}
+
+			if got != want {
+				t.Errorf("%q mode: checkURL(%q) = %q; want %q", mode, test.val, got, want)
+			}
+
+			warned := strings.Contains(logged.String(), "not a valid url")
+			if warned != (mode != "" && test.valid == false) {
+				t.Errorf("%q mode: checkURL(%q) warned = %v", mode, test.val, warned)
+			}
+		}
+	}
+}
+
+func TestCheckURLItemAndPartFields(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["thumbnail_url_a"] = []string{"https://iiif.example.org/1", "iiif.example.org/2", "/3"}
+	doc["url_iiif_manifest_stored"] = "manifest.json"
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item, serviceConfigField{Name: "manifest", Field: "url_iiif_manifest_stored", ValidateURL: "warn"})
+	cfg.Fields.Parts.Indexed = append(cfg.Fields.Parts.Indexed, serviceConfigField{Name: "thumbnail", Field: "thumbnail_url_a", ValidateURL: "drop"})
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	// a warned value is still served
+	if item["manifest"] != "manifest.json" {
+		t.Errorf("manifest = %v; want the original value", item["manifest"])
+	}
+
+	// a dropped part value falls back to the field's default, as a missing value would
+	parts := testParts(t, item)
+	for i, want := range []string{"https://iiif.example.org/1", "Item 2", "Item 3"} {
+		if parts[i]["thumbnail"] != want {
+			t.Errorf("part %d thumbnail = %v; want %s", i+1, parts[i]["thumbnail"], want)
+		}
+	}
+}
+
+func TestEmitEmpty(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["url_iiif_manifest_stored"] = ""
+
+	solr := newFakeSolr(t, doc)
+
+	for _, emit := range []bool{false, true} {
+		cfg := testConfig(solr.server.URL)
+		cfg.Fields.EmitEmpty = emit
+		cfg.Fields.Item = append(cfg.Fields.Item,
+			serviceConfigField{Name: "release_date", Field: "release_date_dt"},
+			serviceConfigField{Name: "collections", Field: "digital_collection_f", MultiValued: true},
+		)
+		cfg.Fields.Parts.Custom = append(cfg.Fields.Parts.Custom, serviceConfigField{
+			Name:       "iiif_manifest_url",
+			Field:      "url_iiif_manifest_stored",
+			CustomInfo: &servceConfigFieldCustomInfo{IIIFManifestURL: &poolConfigFieldTypeIIIFManifestURL{URLPrefix: "https://iiif.example.org"}},
+		})
+
+		p := newTestService(t, cfg)
+
+		item := testItem(t, p, "item-1")
+		part := testParts(t, item)[0]
+
+		releaseDate, hasReleaseDate := item["release_date"]
+		collections, hasCollections := item["collections"]
+		manifest, hasManifest := part["iiif_manifest_url"]
+
+		if emit == false {
+			if hasReleaseDate == true || hasCollections == true || hasManifest == true {
+				t.Errorf("empty fields emitted: release_date %v, collections %v, iiif_manifest_url %v", releaseDate, collections, manifest)
+			}
+			continue
+		}
+
+		if hasReleaseDate == false || releaseDate != nil {
+			t.Errorf("release_date = %v (present: %v); want null", releaseDate, hasReleaseDate)
+		}
+
+		if reflect.DeepEqual(collections, []string{}) == false {
+			t.Errorf("collections = %#v; want an empty list", collections)
+		}
+
+		if hasManifest == false || manifest != nil {
+			t.Errorf("part iiif_manifest_url = %v (present: %v); want null", manifest, hasManifest)
+		}
+
+		// values that are present are unaffected
+		if item["id"] != "item-1" || part["pid"] != "item-1-p1" {
+			t.Errorf("id = %v, pid = %v", item["id"], part["pid"])
+		}
+	}
+}
+
+func TestDefaultThumbnail(t *testing.T) {
+	withThumbnails := testDoc("item-1")
+	withThumbnails["thumbnail_url_a"] = []string{"https://iiif.example.org/1", "", "https://iiif.example.org/3"}
+
+	solr := newFakeSolr(t, withThumbnails, testDoc("item-2"))
+
+	for _, defaultURL := range []string{"", "https://static.example.org/none.png"} {
+		cfg := testConfig(solr.server.URL)
+		cfg.Fields.Parts.Indexed = append(cfg.Fields.Parts.Indexed, serviceConfigField{Name: "thumbnail", Field: "thumbnail_url_a", DefaultPrefix: "Thumbnail", Thumbnail: true})
+		cfg.Fields.Parts.DefaultThumbnailURL = defaultURL
+
+		p := newTestService(t, cfg)
+
+		missing := func(i int) string {
+			if defaultURL == "" {
+				return fmt.Sprintf("Thumbnail %d", i)
+			}
+			return defaultURL
+		}
+
+		// existing thumbnails are kept; only parts lacking one get the default
+
+		mixed := testParts(t, testItem(t, p, "item-1"))
+		for i, want := range []string{"https://iiif.example.org/1", missing(2), "https://iiif.example.org/3"} {
+			if mixed[i]["thumbnail"] != want {
+				t.Errorf("default %q: item-1 part %d thumbnail = %v; want %s", defaultURL, i+1, mixed[i]["thumbnail"], want)
+			}
+		}
+
+		for i, part := range testParts(t, testItem(t, p, "item-2")) {
+			if part["thumbnail"] != missing(i+1) {
+				t.Errorf("default %q: item-2 part %d thumbnail = %v; want %s", defaultURL, i+1, part["thumbnail"], missing(i+1))
+			}
+		}
+	}
+}
+
+// pdfConfig adds a pdf part field, without status checks, to cfg
+func pdfConfig(cfg *serviceConfig, rights *poolConfigFieldTypePdf) *serviceConfig {
+	cfg.Pdf.Endpoints = serviceConfigPdfEndpoints{Generate: "/generate", Download: "/download", Delete: "/delete"}
+
+	field := serviceConfigField{Name: "pdf", Field: "pdf_url_a"}
+	if rights != nil {
+		field.CustomInfo = &servceConfigFieldCustomInfo{Pdf: rights}
+	}
+
+	cfg.Fields.Parts.Custom = append(cfg.Fields.Parts.Custom, field)
+
+	return cfg
+}
+
+func TestPdfRights(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["pdf_url_a"] = []string{"https://pdf.example.org"}
+	doc["rights_wrapper_url_a"] = []string{"", "https://rights.example.org/item-1-p2", ""}
+
+	collection := testDoc("item-2")
+	collection["pdf_url_a"] = []string{"https://pdf.example.org"}
+	collection["digital_collection_f"] = []string{"licensed"}
+
+	solr := newFakeSolr(t, doc, collection)
+
+	// parts with a rights wrapper are restricted, whatever its value
+
+	p := newTestService(t, pdfConfig(testConfig(solr.server.URL), &poolConfigFieldTypePdf{
+		RightsField:        "rights_wrapper_url_a",
+		RightsWrapperField: "rights_wrapper_url_a",
+	}))
+
+	parts := testParts(t, testItem(t, p, "item-1"))
+
+	for i, part := range parts {
+		pdf := part["pdf"].(map[string]interface{})
+		urls := pdf["urls"].(map[string]interface{})
+
+		if i == 1 {
+			if pdf["restricted"] != true || reflect.DeepEqual(urls, map[string]interface{}{"rights_wrapper": "https://rights.example.org/item-1-p2"}) == false {
+				t.Errorf("restricted part pdf = %v; want only the rights wrapper url", pdf)
+			}
+			if _, ok := pdf["status"]; ok == true {
+				t.Errorf("restricted part has a pdf status: %v", pdf)
+			}
+			continue
+		}
+
+		if _, ok := pdf["restricted"]; ok == true {
+			t.Errorf("part %d is restricted: %v", i+1, pdf)
+		}
+
+		pid := part["pid"].(string)
+		if urls["generate"] != "https://pdf.example.org/"+pid+"/generate" || urls["download"] != "https://pdf.example.org/"+pid+"/download" {
+			t.Errorf("part %d pdf urls = %v; want the pdf service urls", i+1, urls)
+		}
+	}
+
+	// with restricting values configured, only those values restrict; an item-level value applies to every part
+
+	p = newTestService(t, pdfConfig(testConfig(solr.server.URL), &poolConfigFieldTypePdf{
+		RightsField:  "digital_collection_f",
+		RightsValues: []string{"licensed", "embargoed"},
+	}))
+
+	for i, part := range testParts(t, testItem(t, p, "item-2")) {
+		pdf := part["pdf"].(map[string]interface{})
+		if pdf["restricted"] != true || len(pdf["urls"].(map[string]interface{})) != 0 {
+			t.Errorf("item-2 part %d pdf = %v; want restricted, without urls", i+1, pdf)
+		}
+	}
+
+	for i, part := range testParts(t, testItem(t, p, "item-1")) {
+		if pdf := part["pdf"].(map[string]interface{}); pdf["restricted"] != nil {
+			t.Errorf("item-1 part %d pdf = %v; want unrestricted", i+1, pdf)
+		}
+	}
+}
+
+func TestPdfRightsMismatch(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["pdf_url_a"] = []string{"https://pdf.example.org"}
+	doc["rights_wrapper_url_a"] = []string{"", "https://rights.example.org/item-1-p2"}
+
+	solr := newFakeSolr(t, doc)
+
+	p := newTestService(t, pdfConfig(testConfig(solr.server.URL), &poolConfigFieldTypePdf{
+		RightsField:        "rights_wrapper_url_a",
+		RightsWrapperField: "rights_wrapper_url_a",
+	}))
+
+	logs := captureLog(t)
+
+	// rights that don't line up with the parts restrict every part, even those with an empty value
+
+	for i, part := range testParts(t, testItem(t, p, "item-1")) {
+		pdf := part["pdf"].(map[string]interface{})
+		urls := pdf["urls"].(map[string]interface{})
+
+		if pdf["restricted"] != true || urls["generate"] != nil || urls["download"] != nil {
+			t.Errorf("part %d pdf = %v; want restricted, without pdf service urls", i+1, pdf)
+		}
+	}
+
+	if strings.Contains(logs.String(), "has 2 values for 3 parts") == false {
+		t.Errorf("rights mismatch not logged:\n%s", logs.String())
+	}
+}
+
+func TestNormalizedFieldValues(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["url_iiif_manifest_stored"] = "  https://iiif.example.org/item-1 \n"
+	doc["individual_call_number_a"] = []string{" MSS  1 ", "MSS\n2", "MSS 3"}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item,
+		serviceConfigField{Name: "manifest", Field: "url_iiif_manifest_stored", TrimSpace: true},
+		serviceConfigField{Name: "raw_manifest", Field: "url_iiif_manifest_stored"},
+	)
+	cfg.Fields.Parts.Indexed[1].CollapseSpace = true
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	if item["manifest"] != "https://iiif.example.org/item-1" {
+		t.Errorf("manifest = %q; want it trimmed", item["manifest"])
+	}
+
+	if item["raw_manifest"] != "  https://iiif.example.org/item-1 \n" {
+		t.Errorf("raw_manifest = %q; want it untouched", item["raw_manifest"])
+	}
+
+	for i, part := range testParts(t, item) {
+		if want := fmt.Sprintf("MSS %d", i+1); part["call_number"] != want {
+			t.Errorf("part %d call_number = %q; want %q", i+1, part["call_number"], want)
+		}
+	}
+}
+
+func TestPartTypeFilter(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["alternate_id_a"] = []string{"item-1-p1", "item-1-p2", "item-1-p3", "item-1-p4"}
+	doc["individual_call_number_a"] = []string{"c1", "c2", "c3", "c4"}
+	// any array paralleling the parts can serve as the type field
+	doc["digital_collection_f"] = []string{"page", "supplement", "", "page"}
+
+	solr := newFakeSolr(t, doc)
+
+	tests := []struct {
+		partType       string
+		includeUntyped bool
+		want           []string
+	}{
+		{"", false, []string{"item-1-p1", "item-1-p2", "item-1-p3", "item-1-p4"}},
+		{"page", false, []string{"item-1-p1", "item-1-p4"}},
+		{"page", true, []string{"item-1-p1", "item-1-p3", "item-1-p4"}},
+		{"supplement", false, []string{"item-1-p2"}},
+	}
+
+	for _, test := range tests {
+		cfg := testConfig(solr.server.URL)
+		cfg.Fields.Parts.TypeField = "digital_collection_f"
+		cfg.Fields.Parts.IncludeUntyped = test.includeUntyped
+
+		p := newTestService(t, cfg)
+
+		s := newTestSearch(p, "/api/item/item-1?part_type="+test.partType)
+		s.id = "item-1"
+
+		resp := s.handleItemRequest()
+		if resp.err != nil {
+			t.Fatalf("part_type %q: request failed: %s", test.partType, resp.err.Error())
+		}
+
+		pids := []string{}
+		for _, part := range testParts(t, resp.data.(map[string]interface{})) {
+			pids = append(pids, part["pid"].(string))
+		}
+
+		if reflect.DeepEqual(pids, test.want) == false {
+			t.Errorf("part_type %q (include untyped: %v) = %v; want %v", test.partType, test.includeUntyped, pids, test.want)
+		}
+	}
+}
+
+func TestAlternateIDsItemField(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item, serviceConfigField{Name: "alternate_ids", Field: "alternate_id_a", MultiValued: true})
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	if got := item["alternate_ids"]; reflect.DeepEqual(got, []string{"item-1-p1", "item-1-p2", "item-1-p3"}) == false {
+		t.Errorf("alternate_ids = %v; want every alternate id", got)
+	}
+}
+
+func TestMultiValuedItemFields(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["individual_call_number_a"] = []string{"", "MSS 2", "MSS 3"}
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Item = append(cfg.Fields.Item,
+		serviceConfigField{Name: "call_numbers", Field: "individual_call_number_a", MultiValued: true},
+		serviceConfigField{Name: "call_number", Field: "individual_call_number_a"},
+		serviceConfigField{Name: "first_alternate_id", Field: "alternate_id_a"},
+		serviceConfigField{Name: "record_id", Field: "id", MultiValued: true},
+	)
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	// multi-valued fields get every nonempty value, as a list even when there is just one
+	if got := item["call_numbers"]; reflect.DeepEqual(got, []string{"MSS 2", "MSS 3"}) == false {
+		t.Errorf("call_numbers = %#v; want the nonempty values", got)
+	}
+
+	if got := item["record_id"]; reflect.DeepEqual(got, []string{"item-1"}) == false {
+		t.Errorf("record_id = %#v; want a single-element list", got)
+	}
+
+	// single-valued fields get just the first value, as a string (which may be empty, and so omitted)
+	if got, ok := item["call_number"]; ok == true {
+		t.Errorf("call_number = %#v; want it omitted, as its first value is empty", got)
+	}
+
+	if got := item["first_alternate_id"]; got != "item-1-p1" {
+		t.Errorf("first_alternate_id = %#v; want the first value", got)
+	}
+}
+
+func TestResolvedLookup(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.LookupFields = []string{"id", "alternate_id_a"}
+
+	p := newTestService(t, cfg)
+
+	if item := testItem(t, p, "item-1"); item["resolved"] != nil {
+		t.Errorf("primary lookup reported as resolved: %v", item["resolved"])
+	}
+
+	want := map[string]interface{}{"id": "item-1", "matched_by": "alternate_id_a"}
+	if item := testItem(t, p, "item-1-p2"); reflect.DeepEqual(item["resolved"], want) == false {
+		t.Errorf("resolved = %v; want %v", item["resolved"], want)
+	}
+}
+
+func TestResolvedRealTimeGet(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+
+	// not yet searchable, so only real-time get finds it
+	solr.respond = func(w http.ResponseWriter, req solrRequestJSON) {
+		solr.writeDocs(w, nil)
+	}
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.RealTimeGet = "get"
+	cfg.Solr.LookupFields = []string{"id_s", "alternate_id_a"}
+
+	p := newTestService(t, cfg)
+
+	if item := testItem(t, p, "item-1"); item["resolved"] != nil {
+		t.Errorf("real-time get reported as resolved: %v", item["resolved"])
+	}
+
+	if len(solr.rtgs) != 1 {
+		t.Errorf("real-time gets = %d; want 1", len(solr.rtgs))
+	}
+}
+
+func TestRequireTLSPdfURLs(t *testing.T) {
+	secure := testDoc("item-1")
+	secure["pdf_url_a"] = []string{"https://pdf.example.org"}
+
+	insecure := testDoc("item-2")
+	insecure["pdf_url_a"] = []string{"HTTP://pdf.example.org"}
+
+	solr := newFakeSolr(t, secure, insecure)
+
+	for _, requireTLS := range []bool{false, true} {
+		cfg := pdfConfig(testConfig(solr.server.URL), nil)
+		cfg.RequireTLS = requireTLS
+
+		p := newTestService(t, cfg)
+
+		for _, part := range testParts(t, testItem(t, p, "item-1")) {
+			if _, ok := part["pdf"]; ok == false {
+				t.Errorf("require_tls %v: https pdf section omitted for %v", requireTLS, part["pid"])
+			}
+		}
+
+		for _, part := range testParts(t, testItem(t, p, "item-2")) {
+			if _, ok := part["pdf"]; ok == requireTLS {
+				t.Errorf("require_tls %v: http pdf section present = %v for %v", requireTLS, ok, part["pid"])
+			}
+		}
+	}
+}
+
+func TestPadMismatchedParts(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["alternate_id_a"] = []string{"item-1-p1", "item-1-p2"}
+	doc["pdf_url_a"] = []string{"https://pdf.example.org"}
+	doc["url_iiif_manifest_stored"] = "https://iiif.example.org/item-1"
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := pdfConfig(testConfig(solr.server.URL), nil)
+	cfg.Fields.Parts.OnMismatch = "pad"
+	cfg.Fields.Parts.Custom = append(cfg.Fields.Parts.Custom,
+		serviceConfigField{Name: "iiif_manifest_url", Field: "url_iiif_manifest_stored", CustomInfo: &servceConfigFieldCustomInfo{
+			IIIFManifestURL: &poolConfigFieldTypeIIIFManifestURL{URLPrefix: "https://iiif.example.org"},
+		}},
+		serviceConfigField{Name: "thumbnails", CustomInfo: &servceConfigFieldCustomInfo{
+			Thumbnails: &poolConfigFieldTypeThumbnails{URLPrefix: "https://iiif.example.org", Sizes: map[string]string{"small": "!200,200"}},
+		}},
+	)
+
+	p := newTestService(t, cfg)
+
+	parts := testParts(t, testItem(t, p, "item-1"))
+	if len(parts) != 3 {
+		t.Fatalf("parts = %d; want 3", len(parts))
+	}
+
+	// the padded part has an empty pid, not a placeholder, and nothing built from it
+
+	padded := parts[2]
+	if padded["pid"] != "" || padded["call_number"] != "c3" {
+		t.Errorf("padded part pid = %q, call_number = %v; want an empty pid and c3", padded["pid"], padded["call_number"])
+	}
+
+	for _, name := range []string{"pdf", "iiif_manifest_url", "thumbnails"} {
+		if _, ok := padded[name]; ok == true {
+			t.Errorf("padded part has a %s section: %v", name, padded[name])
+		}
+
+		for i, part := range parts[:2] {
+			if _, ok := part[name]; ok == false {
+				t.Errorf("part %d lacks a %s section", i+1, name)
+			}
+		}
+	}
+}
+
+func TestZeroMinPartsEmptyList(t *testing.T) {
+	solr := newFakeSolr(t, map[string]interface{}{"id": "item-1"})
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Parts.Indexed[0].Required = false
+	cfg.Fields.Parts.MinParts = "0"
+
+	p := newTestService(t, cfg)
+
+	item := testItem(t, p, "item-1")
+
+	data, _ := json.Marshal(item)
+	if strings.Contains(string(data), `"parts":[]`) == false {
+		t.Errorf("item = %s; want an empty parts list", data)
+	}
+}
+
+func TestCollectionURLAssembledParts(t *testing.T) {
+	multi := testDoc("item-1")
+
+	// two solr entries, but one part once the repeated pid is dropped
+	repeated := testDoc("item-2")
+	repeated["alternate_id_a"] = []string{"item-2-p1", "item-2-p1"}
+	repeated["individual_call_number_a"] = []string{"c1", "c1"}
+
+	solr := newFakeSolr(t, multi, repeated)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Parts.OnDuplicatePid = "dedupe"
+	cfg.Fields.Custom = append(cfg.Fields.Custom, serviceConfigField{Name: "iiif_collection_url", CustomInfo: &servceConfigFieldCustomInfo{
+		IIIFCollectionURL: &poolConfigFieldTypeIIIFCollectionURL{URLPrefix: "https://iiif.example.org/collections"},
+	}})
+
+	p := newTestService(t, cfg)
+
+	if url := testItem(t, p, "item-1")["iiif_collection_url"]; url != "https://iiif.example.org/collections/item-1" {
+		t.Errorf("multi-part iiif_collection_url = %v; want the collection url", url)
+	}
+
+	if url, ok := testItem(t, p, "item-2")["iiif_collection_url"]; ok == true {
+		t.Errorf("single-part iiif_collection_url = %v; want none", url)
+	}
+}
+
+func TestDigitizationPresentParts(t *testing.T) {
+	doc := testDoc("item-1")
+	doc["alternate_id_a"] = []string{"item-1-p1", "item-1-p1", "item-1-p2", "item-1-p3"}
+	doc["individual_call_number_a"] = []string{"c1", "c1", "c2", "c3"}
+	doc["digital_collection_f"] = []string{"page", "page", "supplement", "page"}
+	doc["expected_parts_i"] = 4
+
+	solr := newFakeSolr(t, doc)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Fields.Parts.OnDuplicatePid = "dedupe"
+	cfg.Fields.Parts.TypeField = "digital_collection_f"
+	cfg.Fields.Custom = append(cfg.Fields.Custom, serviceConfigField{Name: "digitization", Field: "expected_parts_i"})
+
+	p := newTestService(t, cfg)
+
+	// the repeated pid is not a part, but the supplement filtered out of this response still is
+
+	s := newTestSearch(p, "/api/item/item-1?part_type=page")
+	s.id = "item-1"
+
+	resp := s.handleItemRequest()
+	if resp.err != nil {
+		t.Fatalf("request failed: %s", resp.err.Error())
+	}
+
+	item := resp.data.(map[string]interface{})
+
+	if parts := testParts(t, item); len(parts) != 2 {
+		t.Errorf("parts = %d; want 2 pages", len(parts))
+	}
+
+	want := map[string]interface{}{"expected": 4, "present": 3, "complete": false}
+	if reflect.DeepEqual(item["digitization"], want) == false {
+		t.Errorf("digitization = %v; want %v", item["digitization"], want)
+	}
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
 )
 
 // git commit used for this build; supplied at compile time
@@ -22,26 +35,67 @@ type serviceVersion struct {
 	GitCommit    string `json:"git_commit,omitempty"`
 }
 
+// solrDoer is satisfied by *http.Client; allows a fake to stand in for Solr
+type solrDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
 type serviceSolrContext struct {
-	client *http.Client
+	client solrDoer
 	url    string
+	rtgURL string // real-time get url, if configured
 }
 
 type serviceSolr struct {
 	service     serviceSolrContext
 	healthcheck serviceSolrContext
+	cores       map[string]serviceSolrContext // service contexts for additional named cores
+	idRegex     *regexp.Regexp                // requested ids must match this, if set
+	idMaxLength int                           // requested ids must not exceed this length, if non-zero
 }
 
 type servicePdf struct {
+	client   *http.Client
+	download *http.Client // for proxied downloads; no overall time limit, as pdfs can be large
+}
+
+type serviceIiif struct {
 	client *http.Client
 }
 
+// optional behaviors; each is enabled when configured, unless turned off in the features section
+type serviceFeatures struct {
+	realtimeGet    bool
+	iiifCheck      bool
+	highlighting   bool
+	pdfRetries     bool
+	clientDeadline bool
+	embargo        bool
+	selfLinks      bool
+	grouping       bool
+	spellcheck     bool
+	coalescing     bool
+	downloadProxy  bool
+}
+
 type serviceContext struct {
-	randomSource *rand.Rand
-	config       *serviceConfig
-	version      serviceVersion
-	solr         serviceSolr
-	pdf          servicePdf
+	randomSource   *rand.Rand
+	config         *serviceConfig
+	version        serviceVersion
+	versionETag    string // version info is fixed for a given build, so is its etag
+	solr           serviceSolr
+	solrQueries    *queryCoalescer // in-flight solr queries, for sharing among identical requests
+	pdf            servicePdf
+	iiif           serviceIiif
+	features       serviceFeatures
+	routes         gin.RoutesInfo       // registered routes, for building Allow headers
+	panics         prometheus.Counter   // recovered handler panics
+	responseBytes  prometheus.Histogram // serialized item response sizes
+	stats          *serviceStats        // activity snapshot for /admin/stats
+	trustedProxies []*net.IPNet         // peers whose forwarding headers identify the client
+	ignoreStatus   int                  // status code returned for ignored paths
+	redactRegex    *regexp.Regexp       // matches field:value pairs to be masked in logs; nil if none
+	logSampleRate  float64              // fraction of successful requests that are fully logged
 }
 
 type stringValidator struct {
@@ -89,12 +143,20 @@ func (p *serviceContext) initVersion() {
 	log.Printf("[SERVICE] version.BuildVersion = [%s]", p.version.BuildVersion)
 	log.Printf("[SERVICE] version.GoVersion    = [%s]", p.version.GoVersion)
 	log.Printf("[SERVICE] version.GitCommit    = [%s]", p.version.GitCommit)
+
+	sum := sha256.Sum256([]byte(p.version.GitCommit + "\n" + p.version.BuildVersion + "\n" + p.version.GoVersion))
+	p.versionETag = fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:8]))
 }
 
-func httpClientWithTimeouts(conn, read string) *http.Client {
+func httpClientWithTimeouts(conn, read, idle string) *http.Client {
 	connTimeout := integerWithMinimum(conn, 1)
 	readTimeout := integerWithMinimum(read, 1)
 
+	idleTimeout := 90
+	if idle != "" {
+		idleTimeout = integerWithMinimum(idle, 1)
+	}
+
 	client := &http.Client{
 		Timeout: time.Duration(readTimeout) * time.Second,
 		Transport: &http.Transport{
@@ -104,43 +166,398 @@ func httpClientWithTimeouts(conn, read string) *http.Client {
 			}).DialContext,
 			MaxIdleConns:        100, // we are hitting one solr host, so
 			MaxIdleConnsPerHost: 100, // these two values can be the same
-			IdleConnTimeout:     90 * time.Second,
+			IdleConnTimeout:     time.Duration(idleTimeout) * time.Second,
 		},
 	}
 
 	return client
 }
 
+func (p *serviceContext) initServer() {
+	// normalize base path to either empty or "/prefix" form
+	if basePath := strings.Trim(p.config.Server.BasePath, "/"); basePath != "" {
+		p.config.Server.BasePath = "/" + basePath
+	} else {
+		p.config.Server.BasePath = ""
+	}
+
+	if len(nonemptyValues(p.config.Server.IgnorePaths)) == 0 {
+		p.config.Server.IgnorePaths = []string{"/favicon.ico"}
+	}
+
+	p.config.Server.PublicBaseURL = strings.TrimRight(p.config.Server.PublicBaseURL, "/")
+
+	p.config.Server.RequiredHeaders = nonemptyValues(p.config.Server.RequiredHeaders)
+
+	p.config.Entitlement.Values = nonemptyValues(p.config.Entitlement.Values)
+	if p.config.Entitlement.Claim != "" && len(p.config.Entitlement.Values) == 0 {
+		p.config.Entitlement.Values = []string{"true"}
+	}
+
+	if p.config.Server.TrailingSlash == "" {
+		p.config.Server.TrailingSlash = "redirect"
+	}
+
+	// server timeouts guard against slow clients holding connections open
+
+	if p.config.Server.ReadTimeout == "" {
+		p.config.Server.ReadTimeout = "30"
+	}
+
+	if p.config.Server.ReadHeaderTimeout == "" {
+		p.config.Server.ReadHeaderTimeout = "10"
+	}
+
+	if p.config.Server.WriteTimeout == "" {
+		p.config.Server.WriteTimeout = "60"
+	}
+
+	p.ignoreStatus = http.StatusNoContent
+	if p.config.Server.IgnoreStatus != "" {
+		p.ignoreStatus = integerWithMinimum(p.config.Server.IgnoreStatus, http.StatusOK)
+	}
+
+	p.stats = newServiceStats()
+
+	log.Printf("[SERVICE] base path           = [%s]", p.config.Server.BasePath)
+	log.Printf("[SERVICE] ignore paths        = [%s]", strings.Join(p.config.Server.IgnorePaths, ", "))
+	log.Printf("[SERVICE] ignore status       = [%d]", p.ignoreStatus)
+	log.Printf("[SERVICE] favicon file        = [%s]", p.config.Server.FaviconFile)
+	log.Printf("[SERVICE] trailing slash      = [%s]", p.config.Server.TrailingSlash)
+	log.Printf("[SERVICE] public base url     = [%s]", p.config.Server.PublicBaseURL)
+	log.Printf("[SERVICE] required headers    = [%s]", strings.Join(p.config.Server.RequiredHeaders, ", "))
+
+	p.config.Server.TrustedProxies = nonemptyValues(p.config.Server.TrustedProxies)
+
+	for _, entry := range p.config.Server.TrustedProxies {
+		if network, err := proxyNetwork(entry); err == nil {
+			p.trustedProxies = append(p.trustedProxies, network)
+		}
+	}
+
+	log.Printf("[SERVICE] trusted proxies     = [%s]", strings.Join(p.config.Server.TrustedProxies, ", "))
+	log.Printf("[SERVICE] entitlement claim   = [%s] (values: %s)", p.config.Entitlement.Claim, strings.Join(p.config.Entitlement.Values, ", "))
+	log.Printf("[SERVICE] retry budget        = [%s]", p.config.Server.RetryBudget)
+	log.Printf("[SERVICE] read timeout        = [%s]", p.config.Server.ReadTimeout)
+	log.Printf("[SERVICE] read header timeout = [%s]", p.config.Server.ReadHeaderTimeout)
+	log.Printf("[SERVICE] write timeout       = [%s]", p.config.Server.WriteTimeout)
+	log.Printf("[SERVICE] gzip min bytes      = [%s]", p.config.Server.Compression.MinBytes)
+	log.Printf("[SERVICE] gzip max bytes      = [%s]", p.config.Server.Compression.MaxBytes)
+	log.Printf("[SERVICE] max response bytes  = [%s] (%s)", p.config.Server.ResponseSize.MaxBytes, p.config.Server.ResponseSize.Mode)
+}
+
+func (p *serviceContext) initMetrics(registerer prometheus.Registerer) {
+	p.panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "virgo4_digital_content_ws_panics_total",
+		Help: "Number of handler panics recovered.",
+	})
+
+	registerer.MustRegister(p.panics)
+
+	p.responseBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "virgo4_digital_content_ws_item_response_bytes",
+		Help:    "Size of serialized item responses.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+	})
+
+	registerer.MustRegister(p.responseBytes)
+}
+
+func (p *serviceContext) initLog() {
+	p.logSampleRate = 1.0
+
+	if p.config.Log.SampleRate != "" {
+		rate, err := strconv.ParseFloat(p.config.Log.SampleRate, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Printf("[SERVICE] invalid log sample rate: [%s]", p.config.Log.SampleRate)
+			os.Exit(1)
+		}
+
+		p.logSampleRate = rate
+	}
+
+	log.Printf("[SERVICE] log sample rate     = [%0.3f]", p.logSampleRate)
+
+	var fields []string
+
+	for _, field := range nonemptyValues(p.config.Log.RedactFields) {
+		fields = append(fields, regexp.QuoteMeta(field))
+	}
+
+	if len(fields) == 0 {
+		return
+	}
+
+	// field:value, field:"quoted value" (possibly with escaped quotes), or field:(grouped values)
+	p.redactRegex = regexp.MustCompile(fmt.Sprintf(`\b(%s):("(?:[^"\\]|\\.)*"|\([^)]*\)|[^\s)]+)`, strings.Join(fields, "|")))
+
+	log.Printf("[SERVICE] log redact fields   = [%s]", strings.Join(nonemptyValues(p.config.Log.RedactFields), ", "))
+}
+
+func warmupConnections(client solrDoer, url string, headers map[string]string, count int, label string) {
+	// concurrent requests force the transport to open (and then pool) separate connections.
+	// failures are not fatal; the pool simply fills on demand instead.
+	if count <= 0 || url == "" {
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := 0
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest("GET", url, nil)
+			if err == nil {
+				setRequestHeaders(req, headers)
+
+				var res *http.Response
+				if res, err = client.Do(req); err == nil {
+					io.Copy(ioutil.Discard, res.Body)
+					res.Body.Close()
+				}
+			}
+
+			if err != nil {
+				mu.Lock()
+				failures++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	log.Printf("[SERVICE] %s warmup: %d of %d connection requests succeeded", label, count-failures, count)
+}
+
 func (p *serviceContext) initSolr() {
 	// client setup
 
 	serviceCtx := serviceSolrContext{
 		url:    fmt.Sprintf("%s/%s/%s", p.config.Solr.Host, p.config.Solr.Core, p.config.Solr.Clients.Service.Endpoint),
-		client: httpClientWithTimeouts(p.config.Solr.Clients.Service.ConnTimeout, p.config.Solr.Clients.Service.ReadTimeout),
+		client: httpClientWithTimeouts(p.config.Solr.Clients.Service.ConnTimeout, p.config.Solr.Clients.Service.ReadTimeout, p.config.Solr.Clients.Service.IdleTimeout),
+	}
+
+	if p.config.Solr.RealTimeGet != "" {
+		serviceCtx.rtgURL = fmt.Sprintf("%s/%s/%s", p.config.Solr.Host, p.config.Solr.Core, p.config.Solr.RealTimeGet)
 	}
 
 	healthCtx := serviceSolrContext{
 		url:    fmt.Sprintf("%s/%s/%s", p.config.Solr.Host, p.config.Solr.Core, p.config.Solr.Clients.HealthCheck.Endpoint),
-		client: httpClientWithTimeouts(p.config.Solr.Clients.HealthCheck.ConnTimeout, p.config.Solr.Clients.HealthCheck.ReadTimeout),
+		client: httpClientWithTimeouts(p.config.Solr.Clients.HealthCheck.ConnTimeout, p.config.Solr.Clients.HealthCheck.ReadTimeout, p.config.Solr.Clients.HealthCheck.IdleTimeout),
 	}
 
 	solr := serviceSolr{
 		service:     serviceCtx,
 		healthcheck: healthCtx,
+		cores:       make(map[string]serviceSolrContext),
+		idMaxLength: integerWithMinimum(p.config.Solr.IDMaxLength, 0),
+	}
+
+	// named cores share the service client, differing only in url
+
+	for name, core := range p.config.Solr.Cores {
+		coreCtx := serviceSolrContext{
+			url:    fmt.Sprintf("%s/%s/%s", p.config.Solr.Host, core, p.config.Solr.Clients.Service.Endpoint),
+			client: serviceCtx.client,
+		}
+
+		if p.config.Solr.RealTimeGet != "" {
+			coreCtx.rtgURL = fmt.Sprintf("%s/%s/%s", p.config.Solr.Host, core, p.config.Solr.RealTimeGet)
+		}
+
+		solr.cores[name] = coreCtx
+
+		log.Printf("[SERVICE] solr core %-12s = [%s]", name, solr.cores[name].url)
+	}
+
+	if p.config.Solr.IDPattern != "" {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", p.config.Solr.IDPattern))
+		if err != nil {
+			log.Printf("[SERVICE] invalid solr id pattern: %s", err.Error())
+			os.Exit(1)
+		}
+
+		// the pattern must match the whole id, not just some part of it
+		solr.idRegex = re
 	}
 
 	p.solr = solr
+	p.solrQueries = newQueryCoalescer()
 
 	log.Printf("[SERVICE] solr service url     = [%s]", serviceCtx.url)
 	log.Printf("[SERVICE] solr healthcheck url = [%s]", healthCtx.url)
+	log.Printf("[SERVICE] solr realtime url    = [%s]", serviceCtx.rtgURL)
+	log.Printf("[SERVICE] solr group field     = [%s] (collapse: %v)", p.config.Solr.Params.Group.Field, p.config.Solr.Params.Group.Collapse)
+	log.Printf("[SERVICE] solr id pattern      = [%s]", p.config.Solr.IDPattern)
+	log.Printf("[SERVICE] solr id max length   = [%d]", solr.idMaxLength)
+
+	p.config.Solr.LookupFields = nonemptyValues(p.config.Solr.LookupFields)
+	if len(p.config.Solr.LookupFields) == 0 {
+		p.config.Solr.LookupFields = []string{"id"}
+	}
+
+	log.Printf("[SERVICE] solr lookup fields   = [%s]", strings.Join(p.config.Solr.LookupFields, ", "))
+	log.Printf("[SERVICE] solr json.nl format  = [%s]", p.config.Solr.Params.JSONNL)
+	log.Printf("[SERVICE] solr extra headers   = [%s]", headerNames(p.config.Solr.Headers))
+
+	if p.config.Solr.Unavailable.RetryAfter == "" {
+		p.config.Solr.Unavailable.RetryAfter = "30"
+	}
+	p.config.Solr.Unavailable.RetryAfter = strconv.Itoa(integerWithMinimum(p.config.Solr.Unavailable.RetryAfter, 0))
+
+	if p.config.Solr.Unavailable.Message == "" {
+		p.config.Solr.Unavailable.Message = "service temporarily unavailable"
+	}
+
+	log.Printf("[SERVICE] solr unavailable     = [retry after %s: %s]", p.config.Solr.Unavailable.RetryAfter, p.config.Solr.Unavailable.Message)
+
+	// the ping url is a cheap request against the same host as the service url
+	warmupConnections(serviceCtx.client, healthCtx.url, p.config.Solr.Headers, integerWithMinimum(p.config.Server.WarmupConns, 0), "solr")
 }
 
 func (p *serviceContext) initPdf() {
 	// client setup
 
 	p.pdf = servicePdf{
-		client: httpClientWithTimeouts(p.config.Pdf.ConnTimeout, p.config.Pdf.ReadTimeout),
+		client:   httpClientWithTimeouts(p.config.Pdf.ConnTimeout, p.config.Pdf.ReadTimeout, ""),
+		download: httpClientWithTimeouts(p.config.Pdf.ConnTimeout, p.config.Pdf.ReadTimeout, ""),
+	}
+
+	// the read timeout still bounds the wait for the pdf service to start responding
+	p.pdf.download.Transport.(*http.Transport).ResponseHeaderTimeout = p.pdf.download.Timeout
+	p.pdf.download.Timeout = 0
+
+	if len(nonemptyValues(p.config.Pdf.ReadyStatuses)) == 0 {
+		p.config.Pdf.ReadyStatuses = []string{"READY"}
+	}
+
+	// ready statuses are implicitly mapped to the normalized "ready" status
+
+	if p.config.Pdf.StatusMap == nil {
+		p.config.Pdf.StatusMap = make(map[string]string)
+	}
+
+	for _, status := range p.config.Pdf.ReadyStatuses {
+		if _, ok := p.config.Pdf.StatusMap[status]; ok == false {
+			p.config.Pdf.StatusMap[status] = "ready"
+		}
+	}
+
+	if p.config.Pdf.DefaultStatus == "" {
+		p.config.Pdf.DefaultStatus = "unknown"
+	}
+
+	log.Printf("[SERVICE] pdf ready statuses  = [%s]", strings.Join(p.config.Pdf.ReadyStatuses, ", "))
+	log.Printf("[SERVICE] pdf default status  = [%s]", p.config.Pdf.DefaultStatus)
+	log.Printf("[SERVICE] pdf extra headers   = [%s]", headerNames(p.config.Pdf.Headers))
+
+	warmupConnections(p.pdf.client, p.config.Pdf.HealthCheckURL, p.config.Pdf.Headers, integerWithMinimum(p.config.Server.WarmupConns, 0), "pdf")
+}
+
+func (p *serviceContext) initIiif() {
+	// client setup; kept separate from the pdf client so a slow iiif server gets its own (short) timeouts
+
+	p.iiif = serviceIiif{
+		client: httpClientWithTimeouts(p.config.Iiif.ConnTimeout, p.config.Iiif.ReadTimeout, ""),
 	}
+
+	log.Printf("[SERVICE] iiif check reachable = [%v]", p.config.Iiif.CheckReachable)
+}
+
+func (p *serviceContext) initFeatures() {
+	features := []struct {
+		name       string
+		configured bool
+		enabled    *bool
+	}{
+		{"realtime_get", p.config.Solr.RealTimeGet != "", &p.features.realtimeGet},
+		{"iiif_check", p.config.Iiif.CheckReachable, &p.features.iiifCheck},
+		{"highlighting", p.config.Solr.Params.Highlight.Enabled, &p.features.highlighting},
+		{"pdf_retries", integerWithMinimum(p.config.Pdf.Retries, 0) > 0, &p.features.pdfRetries},
+		{"client_deadline", integerWithMinimum(p.config.Server.ClientTimeoutMaxMS, 0) > 0, &p.features.clientDeadline},
+		{"embargo", p.config.Fields.Embargo.Field != "", &p.features.embargo},
+		{"self_links", p.config.Server.PublicBaseURL != "", &p.features.selfLinks},
+		{"grouping", p.config.Solr.Params.Group.Field != "", &p.features.grouping},
+		{"spellcheck", p.config.Solr.Params.Spellcheck.Enabled, &p.features.spellcheck},
+		{"coalescing", p.config.Solr.CoalesceQueries, &p.features.coalescing},
+		{"download_proxy", p.config.Pdf.DownloadProxy, &p.features.downloadProxy},
+	}
+
+	var enabled []string
+	known := make(map[string]bool)
+
+	for _, feature := range features {
+		known[feature.name] = true
+
+		flag, ok := p.config.Features[feature.name]
+		*feature.enabled = feature.configured && (ok == false || flag == true)
+
+		if *feature.enabled == true {
+			enabled = append(enabled, feature.name)
+		}
+	}
+
+	for name := range p.config.Features {
+		if known[name] == false {
+			log.Printf("[SERVICE] ignoring unknown feature: [%s]", name)
+		}
+	}
+
+	log.Printf("[SERVICE] enabled features    = [%s]", strings.Join(enabled, ", "))
+}
+
+func (p *serviceContext) backendURLs() map[string]string {
+	// configured backend urls, by description; urls taken from solr documents are not included
+	urls := make(map[string]string)
+
+	urls["solr host"] = p.config.Solr.Host
+
+	if p.config.Pdf.HealthCheckURL != "" {
+		urls["pdf healthcheck url"] = p.config.Pdf.HealthCheckURL
+	}
+
+	for _, field := range append(append([]serviceConfigField{}, p.config.Fields.Parts.Custom...), p.config.Fields.Custom...) {
+		if field.CustomInfo == nil {
+			continue
+		}
+
+		if info := field.CustomInfo.IIIFManifestURL; info != nil {
+			urls[fmt.Sprintf("custom %s url prefix", field.Name)] = info.URLPrefix
+		}
+
+		if info := field.CustomInfo.Thumbnails; info != nil {
+			urls[fmt.Sprintf("custom %s url prefix", field.Name)] = info.URLPrefix
+		}
+
+		if info := field.CustomInfo.SpriteURL; info != nil {
+			urls[fmt.Sprintf("custom %s url prefix", field.Name)] = info.URLPrefix
+		}
+
+		if info := field.CustomInfo.IIIFCollectionURL; info != nil {
+			urls[fmt.Sprintf("custom %s url prefix", field.Name)] = info.URLPrefix
+		}
+	}
+
+	return urls
+}
+
+// iiif image api size forms: full, max, pct:n, w, ,h, w,h, !w,h (optionally upscaled with ^)
+var iiifSizeRegex = regexp.MustCompile(`^\^?(full|max|pct:\d+(\.\d+)?|\d+,|,\d+|!?\d+,\d+)$`)
+
+func (p *serviceContext) partsCustomField(name string) *serviceConfigField {
+	for i := range p.config.Fields.Parts.Custom {
+		if p.config.Fields.Parts.Custom[i].Name == name {
+			return &p.config.Fields.Parts.Custom[i]
+		}
+	}
+
+	return nil
 }
 
 func (p *serviceContext) validateConfig() {
@@ -154,6 +571,11 @@ func (p *serviceContext) validateConfig() {
 	miscValues.requireValue(p.config.Solr.Host, "solr host")
 	miscValues.requireValue(p.config.Solr.Core, "solr core")
 	miscValues.requireValue(p.config.Solr.Clients.Service.Endpoint, "solr service endpoint")
+
+	for name, core := range p.config.Solr.Cores {
+		miscValues.requireValue(name, "solr named core name")
+		miscValues.requireValue(core, fmt.Sprintf("solr named core %s core", name))
+	}
 	miscValues.requireValue(p.config.Solr.Clients.HealthCheck.Endpoint, "solr healthcheck endpoint")
 	miscValues.requireValue(p.config.Solr.Params.Qt, "solr param qt")
 	miscValues.requireValue(p.config.Solr.Params.DefType, "solr param deftype")
@@ -161,17 +583,149 @@ func (p *serviceContext) validateConfig() {
 	for _, field := range p.config.Fields.Item {
 		miscValues.requireValue(field.Name, "item field name")
 		solrFields.requireValue(field.Field, "item solr field")
+
+		for lang, langField := range field.Languages {
+			solrFields.requireValue(langField, fmt.Sprintf("item %s language %s solr field", field.Name, lang))
+		}
 	}
 
+	thumbnailFields := 0
+
 	for _, field := range p.config.Fields.Parts.Indexed {
 		miscValues.requireValue(field.Name, "indexed parts field name")
 		solrFields.requireValue(field.Field, "indexed parts solr field")
+
+		if field.Thumbnail == true {
+			thumbnailFields++
+		}
+	}
+
+	if p.config.Fields.Parts.DefaultThumbnailURL != "" && thumbnailFields != 1 {
+		log.Printf("[VALIDATE] parts default_thumbnail_url requires exactly one indexed parts field marked thumbnail (found %d)", thumbnailFields)
+		invalid = true
+	}
+
+	solrFields.addValue(p.config.Fields.Parts.TypeField)
+	solrFields.addValue(p.config.Fields.Parts.SortField)
+	solrFields.addValue(p.config.Fields.Parts.CollectionField)
+
+	if len(p.config.Fields.Parts.CollectionMinParts) > 0 && p.config.Fields.Parts.CollectionField == "" {
+		log.Printf("[VALIDATE] parts collection_min_parts requires a parts collection_field")
+		invalid = true
+	}
+	solrFields.addValue(p.config.Fields.Embargo.Field)
+
+	if p.config.RequireTLS == true {
+		backendURLs := p.backendURLs()
+
+		var labels []string
+		for label := range backendURLs {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			if backendURL := backendURLs[label]; strings.HasPrefix(strings.ToLower(backendURL), "https://") == false {
+				log.Printf("[VALIDATE] %s is not https: [%s]", label, backendURL)
+				invalid = true
+			}
+		}
+	}
+
+	// lookup fields are interpolated into the query
+	for _, field := range p.config.Solr.LookupFields {
+		if strings.ContainsAny(field, " \t:\"()") == true {
+			log.Printf("[VALIDATE] invalid solr lookup field: [%s]", field)
+			invalid = true
+		}
+	}
+
+	// the group field is interpolated into solr local params when collapsing
+	if strings.ContainsAny(p.config.Solr.Params.Group.Field, " \t{}") == true {
+		log.Printf("[VALIDATE] invalid solr group field: [%s]", p.config.Solr.Params.Group.Field)
+		invalid = true
+	}
+
+	if p.config.Entitlement.Claim != "" {
+		if _, ok := claimValue(&v4jwt.V4Claims{}, p.config.Entitlement.Claim); ok == false {
+			log.Printf("[VALIDATE] unknown entitlement claim: [%s]", p.config.Entitlement.Claim)
+			invalid = true
+		}
+	}
+
+	for _, entry := range p.config.Server.TrustedProxies {
+		if _, err := proxyNetwork(entry); err != nil {
+			log.Printf("[VALIDATE] invalid server trusted proxy: [%s]", entry)
+			invalid = true
+		}
+	}
+
+	switch p.config.Server.TrailingSlash {
+	case "redirect", "strict", "accept":
+	default:
+		log.Printf("[VALIDATE] invalid server trailing_slash mode: [%s]", p.config.Server.TrailingSlash)
+		invalid = true
+	}
+
+	switch p.config.Fields.Embargo.Mode {
+	case "", "error", "hide":
+	default:
+		log.Printf("[VALIDATE] invalid embargo mode: [%s]", p.config.Fields.Embargo.Mode)
+		invalid = true
+	}
+
+	switch p.config.Fields.Parts.OnMismatch {
+	case "", "fail", "truncate", "pad":
+	default:
+		log.Printf("[VALIDATE] invalid parts on_mismatch mode: [%s]", p.config.Fields.Parts.OnMismatch)
+		invalid = true
+	}
+
+	switch p.config.Solr.Params.JSONNL {
+	case "", "flat", "map", "arrarr", "arrmap", "arrntv":
+	default:
+		log.Printf("[VALIDATE] invalid solr json_nl format: [%s]", p.config.Solr.Params.JSONNL)
+		invalid = true
+	}
+
+	switch p.config.Server.ResponseSize.Mode {
+	case "", "warn", "reject":
+	default:
+		log.Printf("[VALIDATE] invalid server response_size mode: [%s]", p.config.Server.ResponseSize.Mode)
+		invalid = true
+	}
+
+	switch p.config.Fields.Parts.OnDuplicatePid {
+	case "", "warn", "dedupe":
+	default:
+		log.Printf("[VALIDATE] invalid parts on_duplicate_pid mode: [%s]", p.config.Fields.Parts.OnDuplicatePid)
+		invalid = true
+	}
+
+	// pdf and iiif urls are built from each part's pid
+
+	hasPid := false
+	for _, field := range p.config.Fields.Parts.Indexed {
+		if field.Name == "pid" {
+			hasPid = true
+		}
 	}
 
 	for _, field := range p.config.Fields.Parts.Custom {
 		miscValues.requireValue(field.Name, "custom parts field name")
 
+		if (field.Name == "pdf" || field.Name == "iiif_manifest_url" || field.Name == "thumbnails") && hasPid == false {
+			log.Printf("[VALIDATE] custom parts %s field requires a pid indexed parts field", field.Name)
+			invalid = true
+		}
+
 		switch field.Name {
+		case "sequence":
+			// no solr field; value is the part's position in the response
+
+		case "digest":
+			// no solr field; value is a hash over the part's other fields
+
 		case "iiif_manifest_url":
 			solrFields.requireValue(field.Field, fmt.Sprintf("custom parts %s solr field", field.Name))
 
@@ -192,21 +746,230 @@ func (p *serviceContext) validateConfig() {
 		case "pdf":
 			solrFields.requireValue(field.Field, fmt.Sprintf("custom parts %s solr field", field.Name))
 
+			// generate may legitimately be empty (i.e. the pid url itself); status is optional
+			miscValues.requireValue(p.config.Pdf.Endpoints.Download, "pdf download endpoint")
+
+			if p.config.Pdf.Endpoints.Status == "" {
+				log.Printf("[VALIDATE] no pdf status endpoint; pdf status will be reported as [%s]", p.config.Pdf.DefaultStatus)
+			}
+
+			if field.CustomInfo != nil && field.CustomInfo.Pdf != nil {
+				solrFields.requireValue(field.CustomInfo.Pdf.RightsField, fmt.Sprintf("custom parts %s custom info %s section rights field", field.Name, field.Name))
+				solrFields.addValue(field.CustomInfo.Pdf.RightsWrapperField)
+			}
+
+		case "thumbnails":
+			if field.CustomInfo == nil || field.CustomInfo.Thumbnails == nil {
+				log.Printf("[VALIDATE] missing custom parts %s custom info %s section", field.Name, field.Name)
+				invalid = true
+				continue
+			}
+
+			thumbnails := field.CustomInfo.Thumbnails
+
+			miscValues.requireValue(thumbnails.URLPrefix, fmt.Sprintf("custom parts %s custom info %s section url prefix", field.Name, field.Name))
+
+			if len(thumbnails.Sizes) == 0 {
+				log.Printf("[VALIDATE] custom parts %s field has no sizes", field.Name)
+				invalid = true
+			}
+
+			for name, size := range thumbnails.Sizes {
+				miscValues.requireValue(name, fmt.Sprintf("custom parts %s size name", field.Name))
+
+				if iiifSizeRegex.MatchString(size) == false {
+					log.Printf("[VALIDATE] invalid custom parts %s iiif size for %s: [%s]", field.Name, name, size)
+					invalid = true
+				}
+			}
+
 		default:
 			log.Printf("[VALIDATE] unhandled custom field: [%s]", field.Name)
 			invalid = true
 		}
 	}
 
+	for _, field := range p.config.Fields.Custom {
+		miscValues.requireValue(field.Name, "custom item field name")
+
+		switch field.Name {
+		case "has_downloadable_pdf":
+			if p.partsCustomField("pdf") == nil {
+				log.Printf("[VALIDATE] custom item %s field requires a custom parts pdf field", field.Name)
+				invalid = true
+			}
+
+		case "oai_identifier":
+			if field.CustomInfo == nil || field.CustomInfo.OAIIdentifier == nil {
+				log.Printf("[VALIDATE] missing custom item %s custom info %s section", field.Name, field.Name)
+				invalid = true
+				continue
+			}
+
+			repositoryID := field.CustomInfo.OAIIdentifier.RepositoryID
+
+			miscValues.requireValue(repositoryID, fmt.Sprintf("custom item %s custom info %s section repository id", field.Name, field.Name))
+
+			if strings.ContainsAny(repositoryID, ": \t") {
+				log.Printf("[VALIDATE] invalid custom item %s repository id: [%s]", field.Name, repositoryID)
+				invalid = true
+			}
+
+		case "sprite_url":
+			if field.CustomInfo == nil || field.CustomInfo.SpriteURL == nil {
+				log.Printf("[VALIDATE] missing custom item %s custom info %s section", field.Name, field.Name)
+				invalid = true
+				continue
+			}
+
+			prefix := field.CustomInfo.SpriteURL.URLPrefix
+
+			miscValues.requireValue(prefix, fmt.Sprintf("custom item %s custom info %s section url prefix", field.Name, field.Name))
+
+			if u, err := url.Parse(prefix); prefix != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				log.Printf("[VALIDATE] invalid custom item %s url prefix: [%s]", field.Name, prefix)
+				invalid = true
+			}
+
+		case "iiif_collection_url":
+			if field.CustomInfo == nil || field.CustomInfo.IIIFCollectionURL == nil {
+				log.Printf("[VALIDATE] missing custom item %s custom info %s section", field.Name, field.Name)
+				invalid = true
+				continue
+			}
+
+			prefix := field.CustomInfo.IIIFCollectionURL.URLPrefix
+
+			miscValues.requireValue(prefix, fmt.Sprintf("custom item %s custom info %s section url prefix", field.Name, field.Name))
+
+			if u, err := url.Parse(prefix); prefix != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				log.Printf("[VALIDATE] invalid custom item %s url prefix: [%s]", field.Name, prefix)
+				invalid = true
+			}
+
+		case "digitization":
+			// solr field holds the expected number of parts
+			solrFields.requireValue(field.Field, fmt.Sprintf("custom item %s solr field", field.Name))
+
+		default:
+			log.Printf("[VALIDATE] unhandled custom item field: [%s]", field.Name)
+			invalid = true
+		}
+	}
+
+	for raw, status := range p.config.Pdf.StatusMap {
+		switch status {
+		case "ready", "generating", "failed", "missing", "unknown":
+		default:
+			log.Printf("[VALIDATE] invalid normalized pdf status for [%s]: [%s]", raw, status)
+			invalid = true
+		}
+	}
+
+	for _, name := range p.config.Server.HealthCheck.Optional {
+		if name != "solr" && name != "pdf" {
+			log.Printf("[VALIDATE] unknown optional healthcheck dependency: [%s]", name)
+			invalid = true
+		}
+	}
+
+	// ensure no two fields would be assigned to the same response key
+
+	itemNames := map[string]bool{"parts": true, "meta": true, "timing": true, "truncated": true, "resolved": true}
+	if p.features.highlighting == true {
+		itemNames["highlighting"] = true
+	}
+	if p.features.selfLinks == true {
+		itemNames["self"] = true
+	}
+
+	for _, field := range append(append([]serviceConfigField{}, p.config.Fields.Item...), p.config.Fields.Custom...) {
+		if itemNames[field.Name] == true {
+			log.Printf("[VALIDATE] duplicate item field name: [%s]", field.Name)
+			invalid = true
+		}
+
+		itemNames[field.Name] = true
+	}
+
+	for _, field := range append(append([]serviceConfigField{}, p.config.Fields.Item...), p.config.Fields.Parts.Indexed...) {
+		for _, transform := range field.Transforms {
+			known, ok := fieldTransforms[transform.Name]
+			if ok == false {
+				log.Printf("[VALIDATE] unknown transform for field %s: [%s]", field.Name, transform.Name)
+				invalid = true
+				continue
+			}
+
+			if known.needsArg == true && transform.Value == "" {
+				log.Printf("[VALIDATE] transform %s for field %s requires a value", transform.Name, field.Name)
+				invalid = true
+			}
+		}
+	}
+
+	for _, field := range append(append(append([]serviceConfigField{}, p.config.Fields.Item...), p.config.Fields.Parts.Indexed...), p.config.Fields.Parts.Custom...) {
+		switch field.ValidateURL {
+		case "", "warn", "drop":
+		default:
+			log.Printf("[VALIDATE] invalid validate_url mode for field %s: [%s]", field.Name, field.ValidateURL)
+			invalid = true
+		}
+	}
+
+	for _, field := range p.config.Fields.Item {
+		if field.MaxValues != "" && (field.MultiValued == false || integerWithMinimum(field.MaxValues, 0) < 1) {
+			log.Printf("[VALIDATE] max_values must be a positive number, for multi-valued fields only: [%s]", field.Name)
+			invalid = true
+		}
+	}
+
+	for _, field := range append(append([]serviceConfigField{}, p.config.Fields.Custom...), p.config.Fields.Parts.Custom...) {
+		if len(field.Transforms) > 0 {
+			log.Printf("[VALIDATE] transforms are not supported for custom field: [%s]", field.Name)
+			invalid = true
+		}
+	}
+
+	partNames := make(map[string]bool)
+	if p.features.selfLinks == true {
+		partNames["self"] = true
+	}
+
+	for _, field := range append(append([]serviceConfigField{}, p.config.Fields.Parts.Indexed...), p.config.Fields.Parts.Custom...) {
+		if partNames[field.Name] == true {
+			log.Printf("[VALIDATE] duplicate parts field name: [%s]", field.Name)
+			invalid = true
+		}
+
+		// each part takes a single element of its indexed arrays, so parts fields cannot be multi-valued
+		if field.MultiValued == true {
+			log.Printf("[VALIDATE] parts field cannot be multi-valued: [%s]", field.Name)
+			invalid = true
+		}
+
+		partNames[field.Name] = true
+	}
+
 	// validate solr fields can actually be found in a solr document
 
 	doc := solrDocument{}
 
 	for _, tag := range solrFields.Values() {
-		if val := doc.getFieldByTag(tag); val == nil {
-			log.Printf("[VALIDATE] field not found in Solr document struct tags: [%s]", tag)
-			invalid = true
+		if val := doc.getFieldByTag(tag); val != nil {
+			continue
 		}
+
+		if p.config.Solr.FoldFieldCase == true {
+			if alias := solrDocumentTagFold(tag); alias != "" {
+				log.Printf("[VALIDATE] WARNING: field only matches Solr document struct tag case-insensitively: [%s] -> [%s]", tag, alias)
+				solrFieldAliases[tag] = alias
+				continue
+			}
+		}
+
+		log.Printf("[VALIDATE] field not found in Solr document struct tags: [%s]", tag)
+		invalid = true
 	}
 
 	// check if anything went wrong anywhere
@@ -224,8 +987,13 @@ func initializeService(cfg *serviceConfig) *serviceContext {
 	p.randomSource = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	p.initVersion()
+	p.initServer()
+	p.initMetrics(prometheus.DefaultRegisterer)
+	p.initLog()
 	p.initSolr()
 	p.initPdf()
+	p.initIiif()
+	p.initFeatures()
 
 	p.validateConfig()
 
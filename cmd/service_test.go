@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	// startup and request logging is noise here; tests that check logs capture it themselves
+	log.SetOutput(ioutil.Discard)
+	gin.SetMode(gin.TestMode)
+
+	os.Exit(m.Run())
+}
+
+// captureLog collects log output for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(ioutil.Discard) })
+
+	return &buf
+}
+
+// testConfig is a minimal valid configuration against the given solr host
+func testConfig(solrHost string) *serviceConfig {
+	cfg := serviceConfig{}
+
+	cfg.Port = "8080"
+	cfg.JWTKey = "secret"
+
+	cfg.Solr.Host = solrHost
+	cfg.Solr.Core = "test"
+	cfg.Solr.Clients.Service = serviceConfigSolrClient{Endpoint: "select", ConnTimeout: "5", ReadTimeout: "5"}
+	cfg.Solr.Clients.HealthCheck = serviceConfigSolrClient{Endpoint: "admin/ping", ConnTimeout: "2", ReadTimeout: "2"}
+	cfg.Solr.Params.Qt = "search"
+	cfg.Solr.Params.DefType = "lucene"
+	cfg.Solr.Params.Fl = []string{"*"}
+
+	cfg.Pdf.ConnTimeout = "2"
+	cfg.Pdf.ReadTimeout = "5"
+
+	cfg.Fields.Item = []serviceConfigField{{Name: "id", Field: "id"}}
+	cfg.Fields.Parts.Indexed = []serviceConfigField{
+		{Name: "pid", Field: "alternate_id_a", Required: true},
+		{Name: "call_number", Field: "individual_call_number_a"},
+	}
+	cfg.Fields.Parts.Custom = []serviceConfigField{{Name: "sequence"}}
+
+	return &cfg
+}
+
+// newTestService initializes a service as initializeService() does, but with metrics
+// registered privately (so that each test can have its own service) and no validation
+func newTestService(t *testing.T, cfg *serviceConfig) *serviceContext {
+	t.Helper()
+
+	p := serviceContext{}
+
+	p.config = cfg
+	p.randomSource = rand.New(rand.NewSource(1))
+
+	p.initVersion()
+	p.initServer()
+	p.initMetrics(prometheus.NewRegistry())
+	p.initLog()
+	p.initSolr()
+	p.initPdf()
+	p.initIiif()
+	p.initFeatures()
+
+	return &p
+}
+
+// newTestSearch creates a search context for a request to the given path (which may carry query options)
+func newTestSearch(p *serviceContext, path string) *searchContext {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", path, nil)
+
+	cl := clientContext{}
+	cl.init(p, c)
+
+	s := searchContext{}
+	s.init(p, &cl)
+
+	return &s
+}
+
+// testItem runs an item request for id, failing the test unless it succeeds
+func testItem(t *testing.T, p *serviceContext, id string) map[string]interface{} {
+	t.Helper()
+
+	s := newTestSearch(p, "/api/item/"+url.PathEscape(id))
+	s.id = id
+
+	resp := s.handleItemRequest()
+	if resp.err != nil {
+		t.Fatalf("item request for %s failed: %d %s", id, resp.status, resp.err.Error())
+	}
+
+	item, ok := resp.data.(map[string]interface{})
+	if ok == false {
+		t.Fatalf("item request for %s returned %T, not an item", id, resp.data)
+	}
+
+	return item
+}
+
+// testParts returns the parts of an assembled item
+func testParts(t *testing.T, item map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+
+	parts, ok := item["parts"].([]map[string]interface{})
+	if ok == false {
+		t.Fatalf("item has no parts: %v", item)
+	}
+
+	return parts
+}
+
+// fakeSolr answers select and real-time get requests from a set of canned documents.
+// queries are expected in the form this service produces, field:"value"; filter
+// queries of the same form (quoted or not) are applied as well.
+type fakeSolr struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	docs     []map[string]interface{}
+	maxScore float64
+	queries  []solrRequestJSON // select request bodies, in order received
+	rtgs     []url.Values      // real-time get parameters, in order received
+	headers  []http.Header     // headers of every request, in order received
+
+	// when set, replaces the canned response to select requests
+	respond func(w http.ResponseWriter, req solrRequestJSON)
+}
+
+func newFakeSolr(t *testing.T, docs ...map[string]interface{}) *fakeSolr {
+	t.Helper()
+
+	f := &fakeSolr{docs: docs}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeSolr) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.headers = append(f.headers, r.Header.Clone())
+	f.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/get"):
+		params := r.URL.Query()
+
+		f.mu.Lock()
+		f.rtgs = append(f.rtgs, params)
+		f.mu.Unlock()
+
+		filters := append([]string{fmt.Sprintf(`id:"%s"`, params.Get("ids"))}, params["fq"]...)
+		f.writeDocs(w, f.matching(filters))
+
+	case strings.HasSuffix(r.URL.Path, "/admin/ping"):
+		w.Write([]byte(`{"responseHeader":{"status":0,"QTime":0},"status":"OK"}`))
+
+	default:
+		var req solrRequestJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		f.queries = append(f.queries, req)
+		respond := f.respond
+		f.mu.Unlock()
+
+		if respond != nil {
+			respond(w, req)
+			return
+		}
+
+		f.writeDocs(w, f.matching(append([]string{req.Params.Q}, req.Params.Fq...)))
+	}
+}
+
+func (f *fakeSolr) writeDocs(w http.ResponseWriter, docs []map[string]interface{}) {
+	res := map[string]interface{}{
+		"responseHeader": map[string]interface{}{"status": 0, "QTime": 1},
+		"response": map[string]interface{}{
+			"numFound": len(docs),
+			"start":    0,
+			"maxScore": f.maxScore,
+			"docs":     docs,
+		},
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// matching returns the documents satisfying every field:value clause
+func (f *fakeSolr) matching(clauses []string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	matches := []map[string]interface{}{}
+
+	for _, doc := range f.docs {
+		matched := true
+
+		for _, clause := range clauses {
+			if fakeClauseMatches(doc, clause) == false {
+				matched = false
+				break
+			}
+		}
+
+		if matched == true {
+			matches = append(matches, doc)
+		}
+	}
+
+	return matches
+}
+
+func fakeClauseMatches(doc map[string]interface{}, clause string) bool {
+	colon := strings.Index(clause, ":")
+	if colon < 0 {
+		return false
+	}
+
+	field := clause[:colon]
+	value := clause[colon+1:]
+
+	// a quoted phrase, with solr's backslash escaping undone
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		var unquoted strings.Builder
+		inner := value[1 : len(value)-1]
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) {
+				i++
+			} else if inner[i] == '"' {
+				// an unescaped quote ends the phrase early: not a single-value query
+				return false
+			}
+			unquoted.WriteByte(inner[i])
+		}
+		value = unquoted.String()
+	}
+
+	switch v := doc[field].(type) {
+	case string:
+		return v == value
+	case []string:
+		return sliceContains(v, value)
+	case []interface{}:
+		for _, elem := range v {
+			if fmt.Sprintf("%v", elem) == value {
+				return true
+			}
+		}
+	case nil:
+		return false
+	default:
+		return fmt.Sprintf("%v", v) == value
+	}
+
+	return false
+}
+
+// requests returns the select requests received so far
+func (f *fakeSolr) requests() []solrRequestJSON {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]solrRequestJSON{}, f.queries...)
+}
+
+// testDoc is a three-part record
+func testDoc(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                       id,
+		"alternate_id_a":           []string{id + "-p1", id + "-p2", id + "-p3"},
+		"individual_call_number_a": []string{"c1", "c2", "c3"},
+	}
+}
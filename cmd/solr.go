@@ -2,23 +2,46 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type solrRequestParams struct {
-	DefType string   `json:"defType,omitempty"`
-	Qt      string   `json:"qt,omitempty"`
-	Sort    string   `json:"sort,omitempty"`
-	Start   int      `json:"start"`
-	Rows    int      `json:"rows"`
-	Fl      []string `json:"fl,omitempty"`
-	Fq      []string `json:"fq,omitempty"`
-	Q       string   `json:"q,omitempty"`
+	DefType    string   `json:"defType,omitempty"`
+	Qt         string   `json:"qt,omitempty"`
+	Sort       string   `json:"sort,omitempty"`
+	Start      int      `json:"start"`
+	Rows       int      `json:"rows"`
+	Fl         []string `json:"fl,omitempty"`
+	Fq         []string `json:"fq,omitempty"`
+	Q          string   `json:"q,omitempty"`
+	Mm         string   `json:"mm,omitempty"`
+	Qf         string   `json:"qf,omitempty"`
+	ShardsPref string   `json:"shards.preference,omitempty"`
+	JSONNL     string   `json:"json.nl,omitempty"`
+	Hl         string   `json:"hl,omitempty"`
+	HlFl       string   `json:"hl.fl,omitempty"`
+	HlSnippets string   `json:"hl.snippets,omitempty"`
+	HlFragsize string   `json:"hl.fragsize,omitempty"`
+	DebugQuery string   `json:"debugQuery,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	GroupField string   `json:"group.field,omitempty"`
+	GroupCount string   `json:"group.ngroups,omitempty"`
+	Spellcheck string   `json:"spellcheck,omitempty"`
+	SpellQ     string   `json:"spellcheck.q,omitempty"`
+	SpellDict  string   `json:"spellcheck.dictionary,omitempty"`
+	SpellCount string   `json:"spellcheck.count,omitempty"`
 }
 
 type solrRequestJSON struct {
@@ -50,6 +73,12 @@ type solrDocument struct {
 	ThumbnailURL         []string `json:"thumbnail_url_a,omitempty"`
 	URLIIIFManifest      string   `json:"url_iiif_manifest_stored,omitempty"`
 	RightsWrapperURL     []string `json:"rights_wrapper_url_a,omitempty"`
+	ReleaseDate          string   `json:"release_date_dt,omitempty"`
+	DigitalCollection    []string `json:"digital_collection_f,omitempty"`
+	ExpectedParts        int      `json:"expected_parts_i,omitempty"`
+	PartSortKey          []string `json:"part_sort_key_a,omitempty"`
+	PageCount            int64    `json:"page_count_i,omitempty"`
+	DLAvailable          *bool    `json:"is_dl_available_b,omitempty"` // pointer, so that false is distinguishable from missing
 	Score                float32  `json:"score,omitempty"`
 }
 
@@ -60,6 +89,22 @@ type solrResponseDocuments struct {
 	Docs     []solrDocument `json:"docs,omitempty"`
 }
 
+type solrGroup struct {
+	GroupValue interface{}           `json:"groupValue,omitempty"`
+	DocList    solrResponseDocuments `json:"doclist,omitempty"`
+}
+
+type solrGroupedField struct {
+	Matches int         `json:"matches,omitempty"`
+	NGroups int         `json:"ngroups,omitempty"`
+	Groups  []solrGroup `json:"groups,omitempty"`
+}
+
+type solrSpellcheck struct {
+	// a named list of term -> suggestion info, in whichever json.nl form was requested
+	Suggestions interface{} `json:"suggestions,omitempty"`
+}
+
 type solrError struct {
 	Metadata []string `json:"metadata,omitempty"`
 	Msg      string   `json:"msg,omitempty"`
@@ -67,15 +112,93 @@ type solrError struct {
 }
 
 type solrResponse struct {
-	ResponseHeader solrResponseHeader    `json:"responseHeader,omitempty"`
-	Response       solrResponseDocuments `json:"response,omitempty"`
-	Debug          interface{}           `json:"debug,omitempty"`
-	Error          solrError             `json:"error,omitempty"`
-	Status         string                `json:"status,omitempty"`
-	meta           *solrMeta             // pointer to struct in corresponding solrRequest
+	ResponseHeader solrResponseHeader             `json:"responseHeader,omitempty"`
+	Response       solrResponseDocuments          `json:"response,omitempty"`
+	Debug          interface{}                    `json:"debug,omitempty"`
+	Error          solrError                      `json:"error,omitempty"`
+	Status         string                         `json:"status,omitempty"`
+	Highlighting   map[string]map[string][]string `json:"highlighting,omitempty"` // doc id -> field -> snippets
+	Grouped        map[string]solrGroupedField    `json:"grouped,omitempty"`      // group field -> groups
+	Spellcheck     solrSpellcheck                 `json:"spellcheck,omitempty"`
+	meta           *solrMeta                      // pointer to struct in corresponding solrRequest
+}
+
+// solrBackendError is an error reported by solr itself in the response payload (possibly
+// alongside an HTTP 200, depending on the proxy).  the solr message can include query
+// and index internals, so it is only logged; clients just see the mapped status.
+type solrBackendError struct {
+	code int
+	msg  string
+}
+
+func (e solrBackendError) Error() string {
+	return fmt.Sprintf("Solr request failed (%d)", e.code)
+}
+
+// solrUnavailableError indicates solr could not be reached at all, or reported itself
+// unavailable (as opposed to a slow or failed query), which clients see as a consistent,
+// retryable outage response
+type solrUnavailableError struct {
+	msg string
+}
+
+func (e solrUnavailableError) Error() string {
+	return e.msg
+}
+
+func solrResponseError(solrErr solrError) error {
+	// solr reporting itself unavailable (e.g. no live replicas) is an outage like any other
+	if solrErr.Code == http.StatusServiceUnavailable {
+		return solrUnavailableError{msg: "Solr is unavailable"}
+	}
+
+	return solrBackendError{code: solrErr.Code, msg: solrErr.Msg}
+}
+
+func solrTransportError(err error) error {
+	// connection-level failures mean solr is unreachable; timeouts and cancellations do not
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("failed to receive Solr response")
+	}
+
+	return solrUnavailableError{msg: "failed to connect to Solr"}
 }
 
+// solrErrorStatus maps a solr query error to the status returned to the client
+func solrErrorStatus(err error) int {
+	if _, ok := err.(solrUnavailableError); ok == true {
+		return http.StatusServiceUnavailable
+	}
+
+	solrErr, ok := err.(solrBackendError)
+	if ok == false {
+		return http.StatusInternalServerError
+	}
+
+	switch {
+	case solrErr.code == http.StatusBadRequest:
+		// only well-formed ids reach solr, but it may still reject the query they produce
+		return http.StatusBadRequest
+
+	case solrErr.code == http.StatusRequestTimeout, solrErr.code == http.StatusGatewayTimeout:
+		return http.StatusGatewayTimeout
+
+	default:
+		// anything else (missing core, bad credentials, solr 5xx) is a backend problem
+		return http.StatusBadGateway
+	}
+}
+
+// configured field name -> solr document tag, for names that only match case-insensitively;
+// populated once at startup, when case-insensitive matching is enabled
+var solrFieldAliases = make(map[string]string)
+
 func (s *solrDocument) getFieldByTag(tag string) interface{} {
+	if alias, ok := solrFieldAliases[tag]; ok == true {
+		tag = alias
+	}
+
 	rt := reflect.TypeOf(*s)
 
 	if rt.Kind() != reflect.Struct {
@@ -94,9 +217,11 @@ func (s *solrDocument) getFieldByTag(tag string) interface{} {
 }
 
 func (s *solrDocument) getValuesByTag(tag string) []string {
-	// turn all potential values into string slices
+	return solrValueStrings(s.getFieldByTag(tag))
+}
 
-	v := s.getFieldByTag(tag)
+func solrValueStrings(v interface{}) []string {
+	// turn all potential values into string slices
 
 	switch t := v.(type) {
 	case []string:
@@ -109,29 +234,185 @@ func (s *solrDocument) getValuesByTag(tag string) []string {
 		// in case this is ever called for fields such as 'score'
 		return []string{fmt.Sprintf("%0.8f", t)}
 
+	case int:
+		// unset (zero) counts are treated as missing
+		if t == 0 {
+			return []string{}
+		}
+		return []string{strconv.Itoa(t)}
+
+	case int64:
+		if t == 0 {
+			return []string{}
+		}
+		return []string{strconv.FormatInt(t, 10)}
+
+	case bool:
+		return []string{strconv.FormatBool(t)}
+
+	case *bool:
+		if t == nil {
+			return []string{}
+		}
+		return []string{strconv.FormatBool(*t)}
+
 	default:
 		return []string{}
 	}
 }
 
+func solrDocumentTagFold(tag string) string {
+	// returns the solr document tag matching tag case-insensitively, if any
+	for field := range solrDocumentFields() {
+		if strings.EqualFold(field, tag) == true {
+			return field
+		}
+	}
+
+	return ""
+}
+
+func solrDocumentFields() map[string]bool {
+	fields := make(map[string]bool)
+
+	rt := reflect.TypeOf(solrDocument{})
+
+	for i := 0; i < rt.NumField(); i++ {
+		fields[strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+
+	return fields
+}
+
+func (s *searchContext) logUnexpectedFields(body []byte) {
+	// report document fields that Solr returned but solrDocument does not decode;
+	// these are silently dropped, and may indicate index/config drift
+
+	var res struct {
+		Response struct {
+			Docs []map[string]json.RawMessage `json:"docs"`
+		} `json:"response"`
+	}
+
+	if err := json.Unmarshal(body, &res); err != nil {
+		s.log("[SOLR] unable to check for unexpected fields: %s", err.Error())
+		return
+	}
+
+	known := solrDocumentFields()
+
+	var unexpected []string
+
+	for _, doc := range res.Response.Docs {
+		for field := range doc {
+			if known[field] == false && sliceContains(unexpected, field) == false {
+				unexpected = append(unexpected, field)
+			}
+		}
+	}
+
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		s.log("[SOLR] WARNING: response contains unexpected fields: [%s]", strings.Join(unexpected, ", "))
+	}
+}
+
+func (s *searchContext) redact(str string) string {
+	// mask values of configured sensitive fields before logging
+	if s.svc.redactRegex == nil {
+		return str
+	}
+
+	return s.svc.redactRegex.ReplaceAllString(str, "$1:***")
+}
+
+func (s *searchContext) redactedRequestJSON(jsonBytes []byte) string {
+	if s.svc.redactRegex == nil {
+		return string(jsonBytes)
+	}
+
+	redacted := s.solrReq.json
+
+	redacted.Params.Q = s.redact(redacted.Params.Q)
+
+	redacted.Params.Fq = []string{}
+	for _, fq := range s.solrReq.json.Params.Fq {
+		redacted.Params.Fq = append(redacted.Params.Fq, s.redact(fq))
+	}
+
+	redactedBytes, err := json.Marshal(redacted)
+	if err != nil {
+		return "(redacted)"
+	}
+
+	return string(redactedBytes)
+}
+
+// solrPhraseEscaper escapes the characters that would otherwise end a quoted solr phrase early
+var solrPhraseEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// solrFieldQuery matches value exactly against field, as a quoted phrase
+func solrFieldQuery(field, value string) string {
+	return fmt.Sprintf(`%s:"%s"`, field, solrPhraseEscaper.Replace(value))
+}
+
 func (s *searchContext) buildSolrRequest() {
 	var req solrRequest
 
 	//	req.meta.client = s.virgoReq.meta.client
 
-	req.json.Params.Q = fmt.Sprintf(`id:"%s"`, s.id)
+	req.json.Params.Q = solrFieldQuery(s.idField, s.id)
 	req.json.Params.Qt = s.svc.config.Solr.Params.Qt
 	req.json.Params.DefType = s.svc.config.Solr.Params.DefType
 	req.json.Params.Fq = nonemptyValues(s.svc.config.Solr.Params.Fq)
 	req.json.Params.Fl = nonemptyValues(s.svc.config.Solr.Params.Fl)
+	req.json.Params.Mm = s.svc.config.Solr.Params.Mm
+	req.json.Params.Qf = s.svc.config.Solr.Params.Qf
+	req.json.Params.ShardsPref = s.svc.config.Solr.Params.ShardsPreference
+	req.json.Params.JSONNL = s.svc.config.Solr.Params.JSONNL
 	req.json.Params.Start = 0
 	req.json.Params.Rows = 1
 
+	// highlighting params are only sent when enabled
+	if s.svc.features.highlighting == true {
+		hl := s.svc.config.Solr.Params.Highlight
+		req.json.Params.Hl = "true"
+		req.json.Params.HlFl = strings.Join(nonemptyValues(hl.Fl), ",")
+		req.json.Params.HlSnippets = hl.Snippets
+		req.json.Params.HlFragsize = hl.Fragsize
+	}
+
+	// grouping: either collapse to one document per group, or request grouped results
+	if s.svc.features.grouping == true {
+		group := s.svc.config.Solr.Params.Group
+		if group.Collapse == true {
+			req.json.Params.Fq = append(req.json.Params.Fq, fmt.Sprintf("{!collapse field=%s}", group.Field))
+		} else {
+			req.json.Params.Group = "true"
+			req.json.Params.GroupField = group.Field
+			req.json.Params.GroupCount = "true"
+		}
+	}
+
+	// spellcheck the raw id rather than the fielded query; only worth the cost when enabled
+	if s.svc.features.spellcheck == true {
+		spell := s.svc.config.Solr.Params.Spellcheck
+		req.json.Params.Spellcheck = "true"
+		req.json.Params.SpellQ = s.id
+		req.json.Params.SpellDict = spell.Dictionary
+		req.json.Params.SpellCount = spell.Count
+	}
+
+	// query explanations are costly, so are only requested for explain requests
+	if s.explain == true {
+		req.json.Params.DebugQuery = "true"
+	}
+
 	s.solrReq = &req
 }
 
 func (s *searchContext) solrQuery() error {
-	ctx := s.svc.solr.service
+	ctx := s.solrServiceContext()
 
 	s.buildSolrRequest()
 
@@ -141,29 +422,118 @@ func (s *searchContext) solrQuery() error {
 		return fmt.Errorf("failed to marshal Solr JSON")
 	}
 
+	var solrRes solrResponse
+	var err error
+
+	// debug requests need the raw response body, so always make their own call
+
+	if s.svc.features.coalescing == true && s.client.opts.debug == false {
+		var shared bool
+		start := time.Now()
+
+		key := ctx.url + "\n" + string(jsonBytes)
+		solrRes, err, shared = s.svc.solrQueries.do(s.ctx, key, func() (solrResponse, error) {
+			return s.solrFetch(ctx, jsonBytes)
+		})
+
+		if shared == true {
+			s.svc.stats.solrQueryShared()
+			s.solrMS += int64(time.Since(start) / time.Millisecond)
+			s.log("[SOLR] req: [%s] (shared with a concurrent identical query)", s.redact(s.solrReq.json.Params.Q))
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			s.log("[SOLR] gave up waiting for a concurrent identical query: %s", err.Error())
+			err = fmt.Errorf("failed to receive Solr response")
+		}
+	} else {
+		solrRes, err = s.solrFetch(ctx, jsonBytes)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.solrRes = &solrRes
+
+	// log abbreviated results
+
+	logHeader := fmt.Sprintf("[SOLR] res: header: { status = %d, QTime = %d }", solrRes.ResponseHeader.Status, solrRes.ResponseHeader.QTime)
+
+	// quick validation
+	if solrRes.ResponseHeader.Status != 0 {
+		s.err("%s, error: { code = %d, msg = %s }", logHeader, solrRes.Error.Code, solrRes.Error.Msg)
+		return solrResponseError(solrRes.Error)
+	}
+
+	s.solrRes.meta = &s.solrReq.meta
+	s.solrRes.meta.maxScore = s.solrRes.Response.MaxScore
+	s.solrRes.meta.start = s.solrReq.json.Params.Start
+	s.solrRes.meta.numRows = len(s.solrRes.Response.Docs)
+	s.solrRes.meta.totalRows = s.solrRes.Response.NumFound
+
+	if grouped, ok := s.solrRes.Grouped[s.solrReq.json.Params.GroupField]; ok == true {
+		s.flattenGroups(grouped)
+	}
+
+	s.log("%s, body: { start = %d, rows = %d, total = %d, maxScore = %0.2f }", logHeader, solrRes.meta.start, solrRes.meta.numRows, solrRes.meta.totalRows, solrRes.meta.maxScore)
+
+	return nil
+}
+
+func (s *searchContext) solrFetch(ctx serviceSolrContext, jsonBytes []byte) (solrResponse, error) {
+	var solrRes solrResponse
+
 	// we cannot use query parameters for the request due to the
 	// possibility of triggering a 414 response (URI Too Long).
 
 	// instead, write the json to the body of the request.
 	// NOTE: Solr is lenient; GET or POST works fine for this.
 
-	req, reqErr := http.NewRequest("POST", ctx.url, bytes.NewBuffer(jsonBytes))
+	opCtx, cancel := s.operationContext(s.svc.config.Solr.QueryTimeoutMS)
+	defer cancel()
+
+	req, reqErr := http.NewRequestWithContext(opCtx, "POST", ctx.url, bytes.NewBuffer(jsonBytes))
 	if reqErr != nil {
 		s.log("[SOLR] NewRequest() failed: %s", reqErr.Error())
-		return fmt.Errorf("failed to create Solr request")
+		return solrRes, fmt.Errorf("failed to create Solr request")
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	setRequestHeaders(req, s.svc.config.Solr.Headers)
 
 	if s.client.opts.verbose == true {
-		s.log("[SOLR] req: [%s]", string(jsonBytes))
+		s.log("[SOLR] req: [%s]", s.redactedRequestJSON(jsonBytes))
+		if len(s.svc.config.Solr.Headers) > 0 {
+			s.log("[SOLR] req extra headers: [%s]", headerNames(s.svc.config.Solr.Headers))
+		}
 	} else {
-		s.log("[SOLR] req: [%s]", s.solrReq.json.Params.Q)
+		s.log("[SOLR] req: [%s]", s.redact(s.solrReq.json.Params.Q))
 	}
 
 	start := time.Now()
 	res, resErr := ctx.client.Do(req)
+
+	// a pooled connection silently dropped while idle (e.g. by a load balancer) fails immediately;
+	// the query never reached solr, so it is safe to send it once more on a fresh connection
+
+	if resErr != nil && isStaleConnectionError(resErr) == true && opCtx.Err() == nil && s.takeRetry() == true {
+		s.log("[SOLR] stale connection (%s); retrying", resErr.Error())
+
+		req, reqErr = http.NewRequestWithContext(opCtx, "POST", ctx.url, bytes.NewBuffer(jsonBytes))
+		if reqErr != nil {
+			s.log("[SOLR] NewRequest() failed: %s", reqErr.Error())
+			return solrRes, fmt.Errorf("failed to create Solr request")
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		setRequestHeaders(req, s.svc.config.Solr.Headers)
+
+		res, resErr = ctx.client.Do(req)
+	}
+
 	elapsedMS := int64(time.Since(start) / time.Millisecond)
+	s.solrMS += elapsedMS
 
 	// external service failure logging (scenario 1)
 
@@ -180,45 +550,268 @@ func (s *searchContext) solrQuery() error {
 
 		s.log("[SOLR] client.Do() failed: %s", resErr.Error())
 		s.log("ERROR: Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, ctx.url, status, errMsg, elapsedMS)
-		return fmt.Errorf("failed to receive Solr response")
+		return solrRes, solrTransportError(resErr)
 	}
 
 	defer res.Body.Close()
 
-	var solrRes solrResponse
+	// in debug mode, keep a copy of the raw response to check for index drift
+	var raw bytes.Buffer
+	body := io.Reader(res.Body)
+	if s.client.opts.debug == true {
+		body = io.TeeReader(res.Body, &raw)
+	}
 
-	decoder := json.NewDecoder(res.Body)
+	decoder := json.NewDecoder(body)
 
 	// external service failure logging (scenario 2)
 
 	if decErr := decoder.Decode(&solrRes); decErr != nil {
 		s.log("[SOLR] Decode() failed: %s", decErr.Error())
 		s.log("ERROR: Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, ctx.url, http.StatusInternalServerError, decErr.Error(), elapsedMS)
-		return fmt.Errorf("failed to decode Solr response")
+		return solrRes, fmt.Errorf("failed to decode Solr response")
 	}
 
 	// external service success logging
 
 	s.log("Successful Solr response from %s %s. Elapsed Time: %d (ms)", req.Method, ctx.url, elapsedMS)
 
-	s.solrRes = &solrRes
+	if s.client.opts.debug == true {
+		s.logUnexpectedFields(raw.Bytes())
+	}
 
-	// log abbreviated results
+	s.svc.stats.solrQueried(elapsedMS)
 
-	logHeader := fmt.Sprintf("[SOLR] res: header: { status = %d, QTime = %d }", solrRes.ResponseHeader.Status, solrRes.ResponseHeader.QTime)
+	return solrRes, nil
+}
+
+func (s *searchContext) solrLookup() error {
+	// an id may really be an alternate id or similar, so try each configured field in turn
+
+	for i, field := range s.svc.config.Solr.LookupFields {
+		s.idField = field
+		s.lookupIdx = i
+
+		if err := s.solrQuery(); err != nil {
+			return err
+		}
+
+		if s.solrRes.meta.numRows > 0 {
+			s.log("[SOLR] id matched by lookup field: [%s]", field)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (r *solrResponse) spellingSuggestions() []string {
+	// collects suggested words across all misspelled terms, in order
+	var suggestions []string
+
+	for _, entry := range namedListValues(r.Spellcheck.Suggestions) {
+		info, ok := entry.(map[string]interface{})
+		if ok == false {
+			continue
+		}
+
+		words, _ := info["suggestion"].([]interface{})
+
+		for _, word := range words {
+			// extended results report suggestions as {"word": ..., "freq": ...}
+			if extended, ok := word.(map[string]interface{}); ok == true {
+				word = extended["word"]
+			}
+
+			if str, ok := word.(string); ok == true && sliceContains(suggestions, str) == false {
+				suggestions = append(suggestions, str)
+			}
+		}
+	}
+
+	return suggestions
+}
+
+// solrNamedValue is one entry of a solr named list
+type solrNamedValue struct {
+	name  string
+	value interface{}
+}
+
+// namedListEntries returns the entries of a solr named list, in order, for any json.nl form:
+//
+//	flat:   ["a", 1, "b", 2]
+//	map:    {"a": 1, "b": 2} (unordered once decoded; returned in name order)
+//	arrarr: [["a", 1], ["b", 2]]
+//	arrmap: [{"a": 1}, {"b": 2}]
+//	arrntv: [{"name": "a", "type": "int", "value": 1}, ...]
+func namedListEntries(nl interface{}) []solrNamedValue {
+	var entries []solrNamedValue
+
+	switch list := nl.(type) {
+	case map[string]interface{}:
+		names := []string{}
+		for name := range list {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entries = append(entries, solrNamedValue{name: name, value: list[name]})
+		}
+
+	case []interface{}:
+		for i := 0; i < len(list); i++ {
+			switch entry := list[i].(type) {
+			case []interface{}:
+				if len(entry) == 2 {
+					name, _ := entry[0].(string)
+					entries = append(entries, solrNamedValue{name: name, value: entry[1]})
+				}
+
+			case map[string]interface{}:
+				_, typed := entry["type"]
+				if value, ok := entry["value"]; ok == true && typed == true && len(entry) == 3 {
+					name, _ := entry["name"].(string)
+					entries = append(entries, solrNamedValue{name: name, value: value})
+					continue
+				}
+
+				for name, value := range entry {
+					entries = append(entries, solrNamedValue{name: name, value: value})
+				}
+
+			default:
+				// flat: the name, followed by its value
+				if i+1 < len(list) {
+					name, _ := entry.(string)
+					entries = append(entries, solrNamedValue{name: name, value: list[i+1]})
+				}
+				i++
+			}
+		}
+	}
+
+	return entries
+}
+
+// namedListValues returns just the values of a solr named list, in order
+func namedListValues(nl interface{}) []interface{} {
+	var values []interface{}
+
+	for _, entry := range namedListEntries(nl) {
+		values = append(values, entry.value)
+	}
+
+	return values
+}
+
+func (s *searchContext) flattenGroups(grouped solrGroupedField) {
+	// grouped results carry no top-level document list; use the top document of
+	// each group, so that row counts are group counts as with collapsed results
+
+	s.solrRes.Response.Docs = []solrDocument{}
+
+	for _, group := range grouped.Groups {
+		if len(group.DocList.Docs) == 0 {
+			continue
+		}
+
+		s.solrRes.Response.Docs = append(s.solrRes.Response.Docs, group.DocList.Docs[0])
+
+		if group.DocList.MaxScore > s.solrRes.meta.maxScore {
+			s.solrRes.meta.maxScore = group.DocList.MaxScore
+		}
+	}
+
+	s.solrRes.meta.numRows = len(s.solrRes.Response.Docs)
+	s.solrRes.meta.totalRows = grouped.NGroups
+	s.solrRes.Response.NumFound = grouped.Matches
+}
+
+func (s *searchContext) solrRealTimeGet() error {
+	ctx := s.solrServiceContext()
+
+	// real-time get always looks up by the unique key; a match is the record itself, not a resolved one
+	s.idField = "id"
+	s.lookupIdx = 0
+
+	// "ids" (rather than "id") yields a standard response section we can decode as usual
+
+	params := url.Values{}
+	params.Set("ids", s.id)
+
+	// real-time get applies filter queries too, so that it cannot serve records the query would exclude
+	for _, fq := range nonemptyValues(s.svc.config.Solr.Params.Fq) {
+		params.Add("fq", fq)
+	}
+
+	if fl := nonemptyValues(s.svc.config.Solr.Params.Fl); len(fl) > 0 {
+		params.Set("fl", strings.Join(fl, ","))
+	}
+
+	rtgURL := fmt.Sprintf("%s?%s", ctx.rtgURL, params.Encode())
+
+	opCtx, cancel := s.operationContext(s.svc.config.Solr.QueryTimeoutMS)
+	defer cancel()
+
+	req, reqErr := http.NewRequestWithContext(opCtx, "GET", rtgURL, nil)
+	if reqErr != nil {
+		s.log("[SOLR] NewRequest() failed: %s", reqErr.Error())
+		return fmt.Errorf("failed to create Solr request")
+	}
+
+	setRequestHeaders(req, s.svc.config.Solr.Headers)
+
+	s.log("[SOLR] rtg req: [%s]", s.redact(solrFieldQuery("id", s.id)))
+
+	start := time.Now()
+	res, resErr := ctx.client.Do(req)
+	elapsedMS := int64(time.Since(start) / time.Millisecond)
+	s.solrMS += elapsedMS
+
+	// external service failure logging (scenario 1)
+
+	if resErr != nil {
+		s.log("[SOLR] client.Do() failed: %s", resErr.Error())
+		s.log("ERROR: Failed response from %s %s - %s. Elapsed Time: %d (ms)", req.Method, ctx.rtgURL, resErr.Error(), elapsedMS)
+		return solrTransportError(resErr)
+	}
+
+	defer res.Body.Close()
+
+	var solrRes solrResponse
+
+	decoder := json.NewDecoder(res.Body)
+
+	// external service failure logging (scenario 2)
+
+	if decErr := decoder.Decode(&solrRes); decErr != nil {
+		s.log("[SOLR] Decode() failed: %s", decErr.Error())
+		s.log("ERROR: Failed response from %s %s - %d:%s. Elapsed Time: %d (ms)", req.Method, ctx.rtgURL, http.StatusInternalServerError, decErr.Error(), elapsedMS)
+		return fmt.Errorf("failed to decode Solr response")
+	}
+
+	// external service success logging
+
+	s.log("Successful Solr response from %s %s. Elapsed Time: %d (ms)", req.Method, ctx.rtgURL, elapsedMS)
+
+	logHeader := fmt.Sprintf("[SOLR] rtg res: header: { status = %d, QTime = %d }", solrRes.ResponseHeader.Status, solrRes.ResponseHeader.QTime)
 
 	// quick validation
 	if solrRes.ResponseHeader.Status != 0 {
-		s.log("%s, error: { code = %d, msg = %s }", logHeader, solrRes.Error.Code, solrRes.Error.Msg)
-		return fmt.Errorf("%d - %s", solrRes.Error.Code, solrRes.Error.Msg)
+		s.err("%s, error: { code = %d, msg = %s }", logHeader, solrRes.Error.Code, solrRes.Error.Msg)
+		return solrResponseError(solrRes.Error)
 	}
 
+	s.solrRes = &solrRes
+
 	s.solrRes.meta = &s.solrReq.meta
-	s.solrRes.meta.start = s.solrReq.json.Params.Start
+	s.solrRes.meta.start = 0
 	s.solrRes.meta.numRows = len(s.solrRes.Response.Docs)
 	s.solrRes.meta.totalRows = s.solrRes.Response.NumFound
 
-	s.log("%s, body: { start = %d, rows = %d, total = %d, maxScore = %0.2f }", logHeader, solrRes.meta.start, solrRes.meta.numRows, solrRes.meta.totalRows, solrRes.meta.maxScore)
+	s.log("%s, body: { rows = %d }", logHeader, solrRes.meta.numRows)
 
 	return nil
 }
@@ -226,15 +819,18 @@ func (s *searchContext) solrQuery() error {
 func (s *searchContext) solrPing() error {
 	ctx := s.svc.solr.healthcheck
 
-	req, reqErr := http.NewRequest("GET", ctx.url, nil)
+	req, reqErr := http.NewRequestWithContext(s.ctx, "GET", ctx.url, nil)
 	if reqErr != nil {
 		s.log("[SOLR] NewRequest() failed: %s", reqErr.Error())
 		return fmt.Errorf("failed to create Solr request")
 	}
 
+	setRequestHeaders(req, s.svc.config.Solr.Headers)
+
 	start := time.Now()
 	res, resErr := ctx.client.Do(req)
 	elapsedMS := int64(time.Since(start) / time.Millisecond)
+	s.solrMS += elapsedMS
 
 	// external service failure logging (scenario 1)
 
@@ -276,8 +872,8 @@ func (s *searchContext) solrPing() error {
 
 	// quick validation
 	if solrRes.ResponseHeader.Status != 0 {
-		s.log("%s, error: { code = %d, msg = %s }", logHeader, solrRes.Error.Code, solrRes.Error.Msg)
-		return fmt.Errorf("%d - %s", solrRes.Error.Code, solrRes.Error.Msg)
+		s.err("%s, error: { code = %d, msg = %s }", logHeader, solrRes.Error.Code, solrRes.Error.Msg)
+		return solrResponseError(solrRes.Error)
 	}
 
 	s.log("%s, ping status: %s", logHeader, solrRes.Status)
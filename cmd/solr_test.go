@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// staleDoer fails the first request as a dropped keep-alive connection would, then passes requests through
+type staleDoer struct {
+	client solrDoer
+	calls  int
+}
+
+func (d *staleDoer) Do(req *http.Request) (*http.Response, error) {
+	d.calls++
+
+	if d.calls == 1 {
+		return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: syscall.ECONNRESET}
+	}
+
+	return d.client.Do(req)
+}
+
+func TestSolrQueryDecodesResponse(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"), testDoc("item-2"))
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	s := newTestSearch(p, "/api/item/item-2")
+	s.id = "item-2"
+	s.idField = "id"
+
+	if err := s.solrQuery(); err != nil {
+		t.Fatalf("solrQuery() failed: %s", err.Error())
+	}
+
+	if s.solrRes.meta.numRows != 1 || s.solrRes.meta.totalRows != 1 {
+		t.Errorf("rows = %d, total = %d; want 1, 1", s.solrRes.meta.numRows, s.solrRes.meta.totalRows)
+	}
+
+	doc := s.solrRes.Response.Docs[0]
+
+	if doc.ID != "item-2" {
+		t.Errorf("id = %q; want item-2", doc.ID)
+	}
+
+	if len(doc.AlternateID) != 3 || doc.AlternateID[2] != "item-2-p3" {
+		t.Errorf("alternate ids = %v; want three part pids", doc.AlternateID)
+	}
+
+	reqs := solr.requests()
+	if len(reqs) != 1 || reqs[0].Params.Q != `id:"item-2"` || reqs[0].Params.Rows != 1 {
+		t.Errorf("solr requests = %+v; want a single id query for one row", reqs)
+	}
+}
+
+func TestSolrQueryBackendError(t *testing.T) {
+	solr := newFakeSolr(t)
+	solr.respond = func(w http.ResponseWriter, req solrRequestJSON) {
+		w.Write([]byte(`{"responseHeader":{"status":400,"QTime":0},"error":{"msg":"undefined field zzz","code":400}}`))
+	}
+
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	err := s.solrQuery()
+
+	var backendErr solrBackendError
+	if errors.As(err, &backendErr) == false {
+		t.Fatalf("solrQuery() error = %v; want a solrBackendError", err)
+	}
+
+	if backendErr.code != http.StatusBadRequest || backendErr.msg != "undefined field zzz" {
+		t.Errorf("backend error = %+v; want code 400 with the solr message", backendErr)
+	}
+
+	if status := solrErrorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("status = %d; want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestSolrQueryUnreachable(t *testing.T) {
+	solr := newFakeSolr(t)
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	solr.server.Close()
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	err := s.solrQuery()
+
+	if _, ok := err.(solrUnavailableError); ok == false {
+		t.Fatalf("solrQuery() error = %v; want a solrUnavailableError", err)
+	}
+
+	if status := solrErrorStatus(err); status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d; want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSolrErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unavailable", solrUnavailableError{msg: "down"}, http.StatusServiceUnavailable},
+		{"bad request", solrBackendError{code: 400}, http.StatusBadRequest},
+		{"solr timeout", solrBackendError{code: 408}, http.StatusGatewayTimeout},
+		{"gateway timeout", solrBackendError{code: 504}, http.StatusGatewayTimeout},
+		{"missing core", solrBackendError{code: 404}, http.StatusBadGateway},
+		{"solr failure", solrBackendError{code: 500}, http.StatusBadGateway},
+		{"other", errors.New("failed to decode Solr response"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if got := solrErrorStatus(test.err); got != test.want {
+			t.Errorf("%s: solrErrorStatus() = %d; want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSolrFetchRetriesStaleConnection(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	doer := &staleDoer{client: p.solr.service.client}
+	p.solr.service.client = doer
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	if err := s.solrQuery(); err != nil {
+		t.Fatalf("solrQuery() failed: %s", err.Error())
+	}
+
+	if doer.calls != 2 || len(solr.requests()) != 1 {
+		t.Errorf("calls = %d, solr requests = %d; want one failed and one retried request", doer.calls, len(solr.requests()))
+	}
+
+	if s.solrRes.meta.numRows != 1 {
+		t.Errorf("rows = %d; want 1", s.solrRes.meta.numRows)
+	}
+}
+
+func TestSolrFetchRetryBudgetExhausted(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Server.RetryBudget = "0"
+
+	p := newTestService(t, cfg)
+
+	doer := &staleDoer{client: p.solr.service.client}
+	p.solr.service.client = doer
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	err := s.solrQuery()
+
+	if _, ok := err.(solrUnavailableError); ok == false {
+		t.Errorf("solrQuery() error = %v; want a solrUnavailableError", err)
+	}
+
+	if doer.calls != 1 || len(solr.requests()) != 0 {
+		t.Errorf("calls = %d, solr requests = %d; want no retry", doer.calls, len(solr.requests()))
+	}
+}
+
+func TestSolrRealTimeGetAppliesFilters(t *testing.T) {
+	public := testDoc("item-1")
+	public["digital_collection_f"] = []string{"public"}
+
+	private := testDoc("item-2")
+	private["digital_collection_f"] = []string{"private"}
+
+	solr := newFakeSolr(t, public, private)
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.RealTimeGet = "get"
+	cfg.Solr.Params.Fq = []string{"digital_collection_f:public", ""}
+
+	p := newTestService(t, cfg)
+
+	// a filtered record is not found by query, and must not be found by real-time get either
+
+	s := newTestSearch(p, "/api/item/item-2")
+	s.id = "item-2"
+
+	resp := s.handleItemRequest()
+
+	if resp.status != http.StatusNotFound {
+		t.Errorf("status = %d; want %d", resp.status, http.StatusNotFound)
+	}
+
+	if len(solr.rtgs) != 1 {
+		t.Fatalf("real-time gets = %d; want 1", len(solr.rtgs))
+	}
+
+	if fq := solr.rtgs[0]["fq"]; len(fq) != 1 || fq[0] != "digital_collection_f:public" {
+		t.Errorf("real-time get fq = %v; want the configured filter", fq)
+	}
+
+	// an unfiltered record is served as usual
+	testItem(t, p, "item-1")
+}
+
+func TestSolrFieldQueryEscapesPhrase(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"item-1", `id:"item-1"`},
+		{`a" OR id:"b`, `id:"a\" OR id:\"b"`},
+		{`trailing\`, `id:"trailing\\"`},
+		{`\"`, `id:"\\\""`},
+	}
+
+	for _, test := range tests {
+		if got := solrFieldQuery("id", test.value); got != test.want {
+			t.Errorf("solrFieldQuery(%q) = %s; want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestSolrQueryCannotEscapeIDPhrase(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"), testDoc(`odd"id`))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.LookupFields = []string{"id", "alternate_id_a"}
+
+	p := newTestService(t, cfg)
+
+	// an id that would otherwise close the phrase and add its own clause matches nothing
+
+	s := newTestSearch(p, "/api/item/x")
+	s.id = `x" OR id:"item-1`
+
+	if resp := s.handleItemRequest(); resp.err == nil {
+		t.Errorf("injected id was served: %v", resp.data)
+	}
+
+	if reqs := solr.requests(); len(reqs) == 0 || reqs[0].Params.Q != `id:"x\" OR id:\"item-1"` {
+		t.Errorf("solr requests = %+v; want the id as a single escaped phrase", reqs)
+	}
+
+	// while ids that really contain such characters, in any lookup field, are still found
+	testItem(t, p, `odd"id`)
+	testItem(t, p, `odd"id-p2`)
+}
+
+func TestSolrQueryMaxScore(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+	solr.maxScore = 1.5
+
+	p := newTestService(t, testConfig(solr.server.URL))
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	if err := s.solrQuery(); err != nil {
+		t.Fatalf("solrQuery() failed: %s", err.Error())
+	}
+
+	if s.solrRes.meta.maxScore != 1.5 {
+		t.Errorf("meta.maxScore = %v; want 1.5", s.solrRes.meta.maxScore)
+	}
+}
+
+func TestSolrValueStrings(t *testing.T) {
+	yes := true
+	no := false
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{
+		{"strings", []string{"a", "b"}, []string{"a", "b"}},
+		{"string", "a", []string{"a"}},
+		{"int", 12, []string{"12"}},
+		{"zero int", 0, []string{}},
+		{"int64", int64(1234567890123), []string{"1234567890123"}},
+		{"zero int64", int64(0), []string{}},
+		{"bool", false, []string{"false"}},
+		{"true pointer", &yes, []string{"true"}},
+		{"false pointer", &no, []string{"false"}},
+		{"nil pointer", (*bool)(nil), []string{}},
+		{"missing", nil, []string{}},
+	}
+
+	for _, test := range tests {
+		if got := solrValueStrings(test.value); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("%s: solrValueStrings(%v) = %q; want %q", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestGetValuesByTag(t *testing.T) {
+	available := false
+
+	doc := solrDocument{ExpectedParts: 3, DLAvailable: &available}
+
+	if got := doc.getValuesByTag("expected_parts_i"); reflect.DeepEqual(got, []string{"3"}) == false {
+		t.Errorf("expected_parts_i = %q; want [3]", got)
+	}
+
+	// unset counts are missing, while a false flag is distinct from an unset one
+
+	if got := doc.getValuesByTag("page_count_i"); len(got) != 0 {
+		t.Errorf("page_count_i = %q; want no values", got)
+	}
+
+	if got := doc.getValuesByTag("is_dl_available_b"); reflect.DeepEqual(got, []string{"false"}) == false {
+		t.Errorf("is_dl_available_b = %q; want [false]", got)
+	}
+
+	doc.DLAvailable = nil
+
+	if got := doc.getValuesByTag("is_dl_available_b"); len(got) != 0 {
+		t.Errorf("unset is_dl_available_b = %q; want no values", got)
+	}
+}
+
+func TestSolrRequestHeaders(t *testing.T) {
+	solr := newFakeSolr(t, testDoc("item-1"))
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.Headers = map[string]string{"Authorization": "Bearer s3cret", "X-Api-Key": "k3y"}
+
+	p := newTestService(t, cfg)
+
+	logged := captureLog(t)
+
+	s := newTestSearch(p, "/api/item/item-1?verbose=true")
+	s.id = "item-1"
+	s.idField = "id"
+
+	if err := s.solrQuery(); err != nil {
+		t.Fatalf("solrQuery() failed: %s", err.Error())
+	}
+
+	sent := solr.headers[len(solr.headers)-1]
+
+	if sent.Get("Authorization") != "Bearer s3cret" || sent.Get("X-Api-Key") != "k3y" {
+		t.Errorf("solr request headers = %v; want the configured headers", sent)
+	}
+
+	// verbose logging names the headers, but never shows their values
+
+	if strings.Contains(logged.String(), "[SOLR] req extra headers: [Authorization, X-Api-Key]") == false {
+		t.Errorf("verbose log does not name the extra headers:\n%s", logged.String())
+	}
+
+	if strings.Contains(logged.String(), "s3cret") == true || strings.Contains(logged.String(), "k3y") == true {
+		t.Errorf("log contains a header value:\n%s", logged.String())
+	}
+}
+
+// marshalledSolrParams returns the solr request params as sent, for the given configuration
+func marshalledSolrParams(t *testing.T, cfg *serviceConfig) map[string]interface{} {
+	t.Helper()
+
+	p := newTestService(t, cfg)
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+	s.buildSolrRequest()
+
+	jsonBytes, err := json.Marshal(s.solrReq.json)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %s", err.Error())
+	}
+
+	var req map[string]map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &req); err != nil {
+		t.Fatalf("Unmarshal() failed: %s", err.Error())
+	}
+
+	return req["params"]
+}
+
+func TestSolrRelevanceParams(t *testing.T) {
+	params := marshalledSolrParams(t, testConfig("http://solr.invalid"))
+
+	for _, name := range []string{"mm", "qf"} {
+		if val, ok := params[name]; ok == true {
+			t.Errorf("unconfigured %s sent as %v", name, val)
+		}
+	}
+
+	cfg := testConfig("http://solr.invalid")
+	cfg.Solr.Params.Mm = "2<75%"
+	cfg.Solr.Params.Qf = "id^10 alternate_id_a"
+
+	params = marshalledSolrParams(t, cfg)
+
+	if params["mm"] != "2<75%" || params["qf"] != "id^10 alternate_id_a" {
+		t.Errorf("mm = %v, qf = %v; want the configured values", params["mm"], params["qf"])
+	}
+}
+
+func TestSolrShardsPreference(t *testing.T) {
+	if val, ok := marshalledSolrParams(t, testConfig("http://solr.invalid"))["shards.preference"]; ok == true {
+		t.Errorf("unconfigured shards.preference sent as %v", val)
+	}
+
+	cfg := testConfig("http://solr.invalid")
+	cfg.Solr.Params.ShardsPreference = "replica.type:PULL,replica.location:local"
+
+	if val := marshalledSolrParams(t, cfg)["shards.preference"]; val != "replica.type:PULL,replica.location:local" {
+		t.Errorf("shards.preference = %v; want the configured value", val)
+	}
+}
+
+func TestSolrQueryReportedUnavailable(t *testing.T) {
+	solr := newFakeSolr(t)
+	solr.respond = func(w http.ResponseWriter, req solrRequestJSON) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"responseHeader":{"status":503,"QTime":0},"error":{"msg":"no servers hosting shard: shard1","code":503}}`))
+	}
+
+	cfg := testConfig(solr.server.URL)
+	cfg.Solr.Unavailable.RetryAfter = "15"
+	cfg.Solr.Unavailable.Message = "temporarily unavailable"
+
+	p := newTestService(t, cfg)
+
+	s := newTestSearch(p, "/api/item/item-1")
+	s.id = "item-1"
+	s.idField = "id"
+
+	if err := s.solrQuery(); solrErrorStatus(err) != http.StatusServiceUnavailable {
+		t.Fatalf("solrQuery() error = %v; want an unavailable error", err)
+	}
+
+	// clients get the standard outage response
+
+	router := gin.New()
+	router.GET("/api/item/:id", p.itemHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/item/item-1", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "15" {
+		t.Errorf("response = %d, Retry-After %q; want 503, 15", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if body := strings.TrimSpace(w.Body.String()); body != `{"error":"temporarily unavailable"}` {
+		t.Errorf("response body = %s", body)
+	}
+}
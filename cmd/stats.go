@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// statsCounts are the activity totals accumulated since startup (or since the last reset)
+type statsCounts struct {
+	since         time.Time
+	itemRequests  int64
+	itemNotFound  int64
+	itemErrors    int64
+	solrQueries   int64
+	solrMS        int64
+	solrShared    int64
+	pdfChecks     int64
+	pdfMS         int64
+	pdfCheckFails int64
+}
+
+// serviceStats provides a human-readable view of service activity, complementing
+// the prometheus metrics
+type serviceStats struct {
+	mu sync.Mutex
+	statsCounts
+}
+
+func newServiceStats() *serviceStats {
+	return &serviceStats{statsCounts: statsCounts{since: time.Now()}}
+}
+
+func (st *serviceStats) itemServed(status int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.itemRequests++
+
+	switch {
+	case status == 404:
+		st.itemNotFound++
+	case status >= 500:
+		st.itemErrors++
+	}
+}
+
+func (st *serviceStats) solrQueried(elapsedMS int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.solrQueries++
+	st.solrMS += elapsedMS
+}
+
+func (st *serviceStats) solrQueryShared() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.solrShared++
+}
+
+func (st *serviceStats) pdfChecked(elapsedMS int64, failed bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.pdfChecks++
+	st.pdfMS += elapsedMS
+
+	if failed == true {
+		st.pdfCheckFails++
+	}
+}
+
+func ratio(num, denom int64) float64 {
+	if denom == 0 {
+		return 0
+	}
+
+	return float64(num) / float64(denom)
+}
+
+// snapshot returns the current stats, optionally starting a new accumulation period
+func (st *serviceStats) snapshot(reset bool) map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stats := make(map[string]interface{})
+
+	stats["since"] = st.since.Format(time.RFC3339)
+	stats["seconds"] = int64(time.Since(st.since) / time.Second)
+
+	stats["item_requests"] = st.itemRequests
+	stats["item_not_found"] = st.itemNotFound
+	stats["item_not_found_rate"] = ratio(st.itemNotFound, st.itemRequests)
+	stats["item_errors"] = st.itemErrors
+
+	stats["solr_queries"] = st.solrQueries
+	stats["solr_avg_ms"] = ratio(st.solrMS, st.solrQueries)
+	stats["solr_shared_queries"] = st.solrShared
+	stats["solr_shared_rate"] = ratio(st.solrShared, st.solrShared+st.solrQueries)
+
+	stats["pdf_checks"] = st.pdfChecks
+	stats["pdf_avg_ms"] = ratio(st.pdfMS, st.pdfChecks)
+	stats["pdf_check_failures"] = st.pdfCheckFails
+
+	if reset == true {
+		st.statsCounts = statsCounts{since: time.Now()}
+	}
+
+	return stats
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+)
+
+// field value transforms, applied in configured order after whitespace normalization
+
+type fieldTransform struct {
+	fn       func(val, arg string) string
+	needsArg bool
+}
+
+var fieldTransforms = map[string]fieldTransform{
+	"trim":           {fn: func(val, arg string) string { return strings.TrimSpace(val) }},
+	"collapse_space": {fn: func(val, arg string) string { return strings.Join(strings.Fields(val), " ") }},
+	"upper":          {fn: func(val, arg string) string { return strings.ToUpper(val) }},
+	"lower":          {fn: func(val, arg string) string { return strings.ToLower(val) }},
+	"strip_prefix":   {fn: strings.TrimPrefix, needsArg: true},
+	"strip_suffix":   {fn: strings.TrimSuffix, needsArg: true},
+}
+
+func applyTransforms(val string, transforms []serviceConfigFieldTransform) string {
+	for _, transform := range transforms {
+		// names are validated at startup
+		val = fieldTransforms[transform.Name].fn(val, transform.Value)
+	}
+
+	return val
+}
@@ -1,7 +1,18 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // miscellaneous utility functions
@@ -17,6 +28,80 @@ func firstElementOf(s []string) string {
 	return val
 }
 
+func partElementOf(s []string, i int, n int) string {
+	// return element i of a slice that parallels n parts, otherwise treat it as item-level
+	if len(s) == n {
+		return s[i]
+	}
+
+	return firstElementOf(s)
+}
+
+func sliceContains(s []string, val string) bool {
+	for _, v := range s {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeValues(val []string, field serviceConfigField) []string {
+	// apply the field's configured whitespace normalization and transforms, if any
+	if field.TrimSpace == false && field.CollapseSpace == false && len(field.Transforms) == 0 {
+		return val
+	}
+
+	res := []string{}
+
+	for _, s := range val {
+		if field.CollapseSpace == true {
+			s = strings.Join(strings.Fields(s), " ")
+		}
+
+		if field.TrimSpace == true {
+			s = strings.TrimSpace(s)
+		}
+
+		s = applyTransforms(s, field.Transforms)
+
+		res = append(res, s)
+	}
+
+	return res
+}
+
+func setRequestHeaders(req *http.Request, headers map[string]string) {
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+}
+
+func headerNames(headers map[string]string) string {
+	// header values may hold credentials, so only names are ever logged
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	// weak comparison, as If-None-Match requires
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
 func nonemptyValues(val []string) []string {
 	res := []string{}
 
@@ -39,3 +124,175 @@ func integerWithMinimum(str string, min int) int {
 
 	return val
 }
+
+func pdfEndpointURL(pdfURL, pid, endpoint string) string {
+	// join without doubled or missing slashes, whatever the configured/indexed values look like
+	res := strings.TrimRight(pdfURL, "/") + "/" + strings.Trim(pid, "/")
+
+	if endpoint = strings.TrimLeft(endpoint, "/"); endpoint != "" {
+		res = res + "/" + endpoint
+	}
+
+	return res
+}
+
+func routeMatchesPath(route string, path string) bool {
+	// reports whether a gin route pattern (with :param/*param segments) matches a request path
+	routeParts := strings.Split(strings.Trim(route, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, part := range routeParts {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+
+		if i >= len(pathParts) {
+			return false
+		}
+
+		if strings.HasPrefix(part, ":") == false && part != pathParts[i] {
+			return false
+		}
+	}
+
+	return len(routeParts) == len(pathParts)
+}
+
+func acceptedLanguages(header string) []string {
+	// parse an Accept-Language header into lowercased language tags, ordered by quality
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	var langs []language
+
+	for _, entry := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ";")
+
+		tag := strings.ToLower(strings.TrimSpace(parts[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if quality > 0 {
+			langs = append(langs, language{tag: tag, quality: quality})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].quality > langs[j].quality })
+
+	var tags []string
+
+	for _, lang := range langs {
+		tags = append(tags, lang.tag)
+	}
+
+	return tags
+}
+
+func clientTimeout(deadline, grpcTimeout string, now time.Time) time.Duration {
+	// X-Request-Deadline holds an absolute RFC 3339 time
+	if deadline != "" {
+		if t, err := time.Parse(time.RFC3339Nano, deadline); err == nil {
+			if remaining := t.Sub(now); remaining > 0 {
+				return remaining
+			}
+
+			// already passed; allow the smallest possible budget
+			return time.Nanosecond
+		}
+	}
+
+	// grpc-timeout holds an integer followed by a unit: H, M, S, m (ms), u (us), or n (ns)
+	if len(grpcTimeout) < 2 {
+		return 0
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[grpcTimeout[len(grpcTimeout)-1]]
+	if ok == false {
+		return 0
+	}
+
+	val, err := strconv.Atoi(grpcTimeout[:len(grpcTimeout)-1])
+	if err != nil || val <= 0 {
+		return 0
+	}
+
+	return time.Duration(val) * unit
+}
+
+func isStaleConnectionError(err error) bool {
+	// reports whether err looks like a reused keep-alive connection that the other end had closed
+
+	// a timeout means the request may well have reached the other end, so is never stale
+	var netErr net.Error
+	if errors.As(err, &netErr) == true && netErr.Timeout() == true {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	msg := err.Error()
+
+	return strings.Contains(msg, "connection reset by peer") || strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "server closed idle connection")
+}
+
+func partDigest(part map[string]interface{}) string {
+	// stable hash of a part's assembled fields, for change detection.  volatile pdf status
+	// values and the self link (which depends on how the service is reached) are excluded;
+	// json encoding sorts map keys, so the result is deterministic
+
+	stable := make(map[string]interface{})
+
+	for key, val := range part {
+		if key == "self" {
+			continue
+		}
+
+		pdf, ok := val.(map[string]interface{})
+		if ok == false {
+			stable[key] = val
+			continue
+		}
+
+		stablePdf := make(map[string]interface{})
+		for pdfKey, pdfVal := range pdf {
+			switch pdfKey {
+			case "status", "normalized_status", "status_error", "status_skipped", "needs_generation":
+			default:
+				stablePdf[pdfKey] = pdfVal
+			}
+		}
+
+		stable[key] = stablePdf
+	}
+
+	data, _ := json.Marshal(stable)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestPartDigestIgnoresPdfStatus(t *testing.T) {
+	part := func(status string, generate bool) map[string]interface{} {
+		return map[string]interface{}{
+			"pid": "item-1-p1",
+			"pdf": map[string]interface{}{
+				"urls":              map[string]interface{}{"generate": "https://pdf.example.org/item-1-p1"},
+				"status":            status,
+				"normalized_status": status,
+				"needs_generation":  generate,
+			},
+		}
+	}
+
+	if partDigest(part("missing", true)) != partDigest(part("READY", false)) {
+		t.Errorf("digest changed with pdf status alone")
+	}
+
+	changed := part("READY", false)
+	changed["pid"] = "item-1-p2"
+
+	if partDigest(changed) == partDigest(part("READY", false)) {
+		t.Errorf("digest unchanged with a different pid")
+	}
+}
+
+// timeoutError is a net.Error timeout whose message would otherwise look like a stale connection
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "read: connection reset by peer (i/o timeout)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsStaleConnectionError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		stale bool
+	}{
+		{"reset", syscall.ECONNRESET, true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"eof", io.EOF, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"wrapped reset", &url.Error{Op: "Post", URL: "http://solr/select", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{"wrapped eof", &url.Error{Op: "Post", URL: "http://solr/select", Err: io.EOF}, true},
+		{"reset message", errors.New("read tcp 10.0.0.1:1234->10.0.0.2:8983: read: connection reset by peer"), true},
+		{"broken pipe message", errors.New("write: broken pipe"), true},
+		{"idle close message", errors.New("http: server closed idle connection"), true},
+		{"timeout", &url.Error{Op: "Post", URL: "http://solr/select", Err: timeoutError{}}, false},
+		{"deadline", &url.Error{Op: "Post", URL: "http://solr/select", Err: context.DeadlineExceeded}, false},
+		{"refused", &url.Error{Op: "Post", URL: "http://solr/select", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, false},
+		{"other", fmt.Errorf("failed to decode Solr response"), false},
+	}
+
+	for _, test := range tests {
+		if got := isStaleConnectionError(test.err); got != test.stale {
+			t.Errorf("%s: isStaleConnectionError(%v) = %v; want %v", test.name, test.err, got, test.stale)
+		}
+	}
+}
+
+func TestNormalizeValues(t *testing.T) {
+	padded := []string{"  MSS 123 \n", "MSS\t\t 456", "", " \n "}
+
+	tests := []struct {
+		name  string
+		field serviceConfigField
+		want  []string
+	}{
+		{"untouched", serviceConfigField{}, padded},
+		{"trimmed", serviceConfigField{TrimSpace: true}, []string{"MSS 123", "MSS\t\t 456", "", ""}},
+		{"collapsed", serviceConfigField{CollapseSpace: true}, []string{"MSS 123", "MSS 456", "", ""}},
+		{"both", serviceConfigField{TrimSpace: true, CollapseSpace: true}, []string{"MSS 123", "MSS 456", "", ""}},
+	}
+
+	for _, test := range tests {
+		if got := normalizeValues(padded, test.field); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("%s: normalizeValues() = %q; want %q", test.name, got, test.want)
+		}
+	}
+
+	// the document's own values are never modified
+	if padded[0] != "  MSS 123 \n" {
+		t.Errorf("normalizeValues() modified its argument: %q", padded)
+	}
+}
+
+func TestPdfEndpointURL(t *testing.T) {
+	want := "https://pdf.example.org/pdf/item-1-p1/status"
+
+	for _, base := range []string{"https://pdf.example.org/pdf", "https://pdf.example.org/pdf/", "https://pdf.example.org/pdf//"} {
+		for _, pid := range []string{"item-1-p1", "/item-1-p1", "item-1-p1/"} {
+			for _, endpoint := range []string{"/status", "status", "//status"} {
+				if got := pdfEndpointURL(base, pid, endpoint); got != want {
+					t.Errorf("pdfEndpointURL(%q, %q, %q) = %q; want %q", base, pid, endpoint, got, want)
+				}
+			}
+		}
+	}
+
+	// without an endpoint, the url is that of the pdf itself
+	for _, endpoint := range []string{"", "/"} {
+		if got := pdfEndpointURL("https://pdf.example.org/pdf/", "item-1-p1", endpoint); got != "https://pdf.example.org/pdf/item-1-p1" {
+			t.Errorf("pdfEndpointURL() with endpoint %q = %q", endpoint, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"strings"
+)
+
+// a bare-bones rendering of an assembled item, for manual verification in a browser
+
+var viewTemplate = template.Must(template.New("view").Funcs(template.FuncMap{
+	"isMap":  func(v interface{}) bool { _, ok := v.(map[string]interface{}); return ok },
+	"isList": func(v interface{}) bool { _, ok := v.([]interface{}); return ok },
+	"isURL": func(v interface{}) bool {
+		str, ok := v.(string)
+		return ok && (strings.HasPrefix(str, "http://") || strings.HasPrefix(str, "https://"))
+	},
+}).Parse(`{{define "value"}}
+{{- if isMap .}}<table>{{range $k, $v := .}}<tr><th>{{$k}}</th><td>{{template "value" $v}}</td></tr>{{end}}</table>
+{{- else if isList .}}<ol>{{range .}}<li>{{template "value" .}}</li>{{end}}</ol>
+{{- else if isURL .}}<a href="{{.}}">{{.}}</a>
+{{- else}}{{.}}{{end}}
+{{- end}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.ID}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+ol { margin: 0; padding-left: 20px; }
+</style>
+</head>
+<body>
+<h1>{{.ID}}</h1>
+{{template "value" .Item}}
+</body>
+</html>
+`))
+
+func renderItemView(id string, data interface{}) ([]byte, error) {
+	// round-trip through json, so the page shows exactly what itemHandler would return
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var item interface{}
+	if err := json.Unmarshal(jsonBytes, &item); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := viewTemplate.Execute(&buf, struct {
+		ID   string
+		Item interface{}
+	}{id, item}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}